@@ -0,0 +1,299 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/newrelic/infra-integrations-sdk/v4/data/event"
+	"github.com/newrelic/infra-integrations-sdk/v4/integration"
+	"github.com/newrelic/nri-conviva/src/api"
+)
+
+const (
+	ALERT_EVENT_CATEGORY   = "ConvivaAlert"
+	DEFAULT_ALERT_SEVERITY = "warning"
+)
+
+var (
+	alertRuleRegex = regexp.MustCompile(
+		`^\s*([A-Za-z_][A-Za-z0-9_.\-]*)\s*(>=|<=|==|!=|>|<)\s*(\S+)\s*(?:\s+for\s+(.+?))?\s*$`,
+	)
+	alertRules = []*alertRule{}
+
+	// raisedAlerts holds the alerts raised in the current run, so that a data
+	// point returned by more than one query raises each alert once.
+	raisedAlerts = map[raisedAlert]bool{}
+)
+
+// AlertRule raises an alert event for every data point of a metric that
+// breaches a threshold. Rule is written as metric operator threshold,
+// optionally followed by for and a comma separated list of dimension=value
+// pairs that the data point must match, e.g.
+// rebuffering_ratio > 0.02 for device-name=Roku.
+type AlertRule struct {
+	Name     string `yaml:"name"`
+	Rule     string `yaml:"rule"`
+	Severity string `yaml:"severity"`
+}
+
+type raisedAlert struct {
+	rule       *alertRule
+	timestamp  int64
+	dimensions string
+}
+
+type alertRule struct {
+	name       string
+	rule       string
+	severity   string
+	metric     string
+	operator   string
+	threshold  float64
+	dimensions map[string]string
+}
+
+func parseAlertRule(a AlertRule) (*alertRule, error) {
+	match := alertRuleRegex.FindStringSubmatch(a.Rule)
+	if match == nil {
+		return nil, fmt.Errorf("invalid alert rule %q", a.Rule)
+	}
+
+	threshold, err := strconv.ParseFloat(match[3], 64)
+	if err != nil {
+		return nil, fmt.Errorf(
+			"invalid threshold %q in alert rule %q",
+			match[3],
+			a.Rule,
+		)
+	}
+
+	r := &alertRule{
+		name:       a.Name,
+		rule:       strings.TrimSpace(a.Rule),
+		severity:   a.Severity,
+		metric:     fieldName(match[1]),
+		operator:   match[2],
+		threshold:  threshold,
+		dimensions: map[string]string{},
+	}
+
+	if r.name == "" {
+		r.name = r.rule
+	}
+
+	if r.severity == "" {
+		r.severity = DEFAULT_ALERT_SEVERITY
+	}
+
+	if match[4] != "" {
+		for _, pair := range strings.Split(match[4], ",") {
+			kv := strings.SplitN(pair, "=", 2)
+			if len(kv) != 2 || strings.TrimSpace(kv[0]) == "" {
+				return nil, fmt.Errorf(
+					"invalid dimension %q in alert rule %q",
+					strings.TrimSpace(pair),
+					a.Rule,
+				)
+			}
+
+			r.dimensions[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+		}
+	}
+
+	return r, nil
+}
+
+func initAlerts(cfg *Config) error {
+	rules := make([]*alertRule, 0, len(cfg.Alerts))
+
+	for _, a := range cfg.Alerts {
+		r, err := parseAlertRule(a)
+		if err != nil {
+			return err
+		}
+
+		rules = append(rules, r)
+	}
+
+	alertRules = rules
+
+	return nil
+}
+
+// breached reports whether the value breaches the threshold of the rule.
+func (r *alertRule) breached(value float64) bool {
+	switch r.operator {
+	case ">":
+		return value > r.threshold
+	case ">=":
+		return value >= r.threshold
+	case "<":
+		return value < r.threshold
+	case "<=":
+		return value <= r.threshold
+	case "==":
+		return value == r.threshold
+	}
+
+	return value != r.threshold
+}
+
+// matches reports whether the Conviva dimensions of a data point include all
+// the dimension values of the rule. Dimension names are compared by their
+// dimensionKey.
+func (r *alertRule) matches(dimensions []api.Dimension) bool {
+	for key, value := range r.dimensions {
+		found := false
+
+		for _, d := range dimensions {
+			if dimensionKey(d.Key) == dimensionKey(key) && d.Value == value {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return false
+		}
+	}
+
+	return true
+}
+
+// resetRaisedAlerts forgets the alerts raised so far, at the start of a run.
+func resetRaisedAlerts() {
+	raisedAlerts = map[raisedAlert]bool{}
+}
+
+// raiseAlert records an alert for a data point and reports whether it was not
+// raised yet in the current run.
+func raiseAlert(r *alertRule, timestamp time.Time, dimensions []api.Dimension) bool {
+	pairs := make([]string, 0, len(dimensions))
+	for _, d := range dimensions {
+		pairs = append(pairs, dimensionKey(d.Key) + "=" + d.Value)
+	}
+	sort.Strings(pairs)
+
+	key := raisedAlert{
+		rule:       r,
+		timestamp:  timestamp.UnixMilli(),
+		dimensions: strings.Join(pairs, ","),
+	}
+
+	if raisedAlerts[key] {
+		return false
+	}
+
+	raisedAlerts[key] = true
+
+	return true
+}
+
+func dimensionString(dimensions []api.Dimension) string {
+	pairs := make([]string, 0, len(dimensions))
+	for _, d := range dimensions {
+		pairs = append(pairs, d.Key + "=" + d.Value)
+	}
+	return strings.Join(pairs, ",")
+}
+
+// addAlertEvents evaluates the alert rules against the values of a data point
+// and adds an alert event for each rule that is breached, unless the same
+// rule was already raised for the data point in this run. The rules match
+// against the Conviva dimensions while the events carry the mapped
+// attributes.
+func addAlertEvents(
+	entity     *integration.Entity,
+	timestamp  time.Time,
+	dimensions []api.Dimension,
+	attributes []api.Dimension,
+	points     []pointValue,
+) error {
+	for _, r := range alertRules {
+		if !r.matches(dimensions) {
+			continue
+		}
+
+		for _, p := range points {
+			if p.name != r.metric || !r.breached(p.value) {
+				continue
+			}
+
+			if !raiseAlert(r, timestamp, dimensions) {
+				continue
+			}
+
+			err := addAlertEvent(
+				entity,
+				timestamp,
+				r,
+				p.value,
+				dimensionString(dimensions),
+				attributes,
+			)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func addAlertEvent(
+	entity     *integration.Entity,
+	timestamp  time.Time,
+	r          *alertRule,
+	value      float64,
+	dimension  string,
+	attributes []api.Dimension,
+) error {
+	summary := fmt.Sprintf(
+		"%s of %s breached %s %s",
+		r.metric,
+		strconv.FormatFloat(value, 'f', -1, 64),
+		r.operator,
+		strconv.FormatFloat(r.threshold, 'f', -1, 64),
+	)
+	if dimension != "" {
+		summary += " for " + dimension
+	}
+
+	e, err := event.New(timestamp, summary, ALERT_EVENT_CATEGORY)
+	if err != nil {
+		return err
+	}
+
+	for _, d := range attributes {
+		err = e.AddAttribute(d.Key, d.Value)
+		if err != nil {
+			return err
+		}
+	}
+
+	alert := map[string]interface{}{
+		"alert":     r.name,
+		"rule":      r.rule,
+		"severity":  r.severity,
+		"metric":    metricMappings.metricName(r.metric),
+		"dimension": dimension,
+		"value":     value,
+		"operator":  r.operator,
+		"threshold": r.threshold,
+	}
+
+	for k, v := range alert {
+		err = e.AddAttribute(k, v)
+		if err != nil {
+			return err
+		}
+	}
+
+	entity.AddEvent(e)
+
+	return nil
+}
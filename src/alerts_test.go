@@ -0,0 +1,90 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/newrelic/infra-integrations-sdk/v4/integration"
+	"github.com/newrelic/nri-conviva/src/api"
+)
+
+func TestAlertRuleMatches(t *testing.T) {
+	r, err := parseAlertRule(AlertRule{
+		Rule: "rebuffering_ratio > 0.02 for device-name=Roku, cdn=Akamai",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		dimensions []api.Dimension
+		want       bool
+	}{
+		{[]api.Dimension{{Key: "device-name", Value: "Roku"}, {Key: "cdn", Value: "Akamai"}}, true},
+		{[]api.Dimension{{Key: "device_name", Value: "Roku"}, {Key: "cdn", Value: "Akamai"}}, true},
+		{[]api.Dimension{{Key: "device-name", Value: "Roku"}}, false},
+		{[]api.Dimension{{Key: "device-name", Value: "Samsung"}, {Key: "cdn", Value: "Akamai"}}, false},
+		{nil, false},
+	}
+
+	for _, tt := range tests {
+		if got := r.matches(tt.dimensions); got != tt.want {
+			t.Errorf("matches(%v) = %v, want %v", tt.dimensions, got, tt.want)
+		}
+	}
+}
+
+func TestAddAlertEventsDeduplicates(t *testing.T) {
+	i, err := integration.New("test", "0.0.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rules := alertRules
+	defer func() {
+		alertRules = rules
+	}()
+
+	err = initAlerts(&Config{
+		Alerts: []AlertRule{
+			{Rule: "rebuffering_ratio > 0.02 for device-name=Roku"},
+			{Rule: "rebuffering_ratio > 0.05"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resetRaisedAlerts()
+
+	now := time.Now()
+	points := []pointValue{{name: "rebuffering_ratio", value: 0.1}}
+	roku := []api.Dimension{{Key: "device-name", Value: "Roku"}}
+
+	add := func(timestamp time.Time, dimensions []api.Dimension) {
+		err := addAlertEvents(i.HostEntity, timestamp, dimensions, nil, points)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	add(now, roku)
+	add(now, []api.Dimension{{Key: "device_name", Value: "Roku"}})
+
+	if got := len(i.HostEntity.Events); got != 2 {
+		t.Errorf("got %d events for the same data point, want 2", got)
+	}
+
+	add(now.Add(time.Minute), roku)
+
+	if got := len(i.HostEntity.Events); got != 4 {
+		t.Errorf("got %d events after a new timestamp, want 4", got)
+	}
+
+	resetRaisedAlerts()
+	add(now, roku)
+
+	if got := len(i.HostEntity.Events); got != 6 {
+		t.Errorf("got %d events after a new run, want 6", got)
+	}
+}
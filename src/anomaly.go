@@ -0,0 +1,328 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/newrelic/infra-integrations-sdk/v4/data/event"
+	"github.com/newrelic/infra-integrations-sdk/v4/integration"
+	"github.com/newrelic/nri-conviva/src/api"
+)
+
+const (
+	ANOMALY_METHOD_STDDEV       = "stddev"
+	ANOMALY_METHOD_MAD          = "mad"
+	ANOMALY_SCORE_METRIC        = "anomaly.score"
+	ANOMALY_EVENT_CATEGORY      = "ConvivaAnomaly"
+	DEFAULT_ANOMALY_WINDOW      = 30
+	DEFAULT_ANOMALY_SENSITIVITY = 3.0
+	MIN_ANOMALY_WINDOW          = 3
+	// MAD_SCALE makes the median absolute deviation comparable to the
+	// standard deviation for normally distributed values.
+	MAD_SCALE = 0.6745
+)
+
+var (
+	anomalyHistories = map[string]*AnomalyHistory{}
+)
+
+// AnomalyConfig enables anomaly detection for the listed metrics of a metric
+// definition. Each point is scored against the previous Window points of the
+// same metric and dimension values, using the mean and standard deviation
+// (stddev) or the median and median absolute deviation (mad), and is an
+// anomaly when the absolute score is at least Sensitivity.
+type AnomalyConfig struct {
+	Metrics     []string `yaml:"metrics"`
+	Window      int      `yaml:"window"`
+	Sensitivity float64  `yaml:"sensitivity"`
+	Method      string   `yaml:"method"`
+}
+
+// AnomalyHistory holds the latest values of a series and the timestamp of the
+// last one so that points seen by a previous query are not added twice.
+type AnomalyHistory struct {
+	LastTimestamp int64     `json:"lastTimestamp"`
+	Values        []float64 `json:"values"`
+}
+
+func checkAnomalyConfig(cfg *Config) error {
+	for i := 0; i < len(cfg.Metrics); i += 1 {
+		m := &cfg.Metrics[i]
+		a := m.Anomaly
+
+		if a == nil {
+			continue
+		}
+
+		if len(a.Metrics) == 0 {
+			return fmt.Errorf(
+				"anomaly detection for %s lists no metrics",
+				m.Name(),
+			)
+		}
+
+		for j := 0; j < len(a.Metrics); j += 1 {
+			a.Metrics[j] = fieldName(a.Metrics[j])
+		}
+
+		if a.Window == 0 {
+			a.Window = DEFAULT_ANOMALY_WINDOW
+		} else if a.Window < MIN_ANOMALY_WINDOW {
+			return fmt.Errorf(
+				"anomaly detection window for %s must be at least %d",
+				m.Name(),
+				MIN_ANOMALY_WINDOW,
+			)
+		}
+
+		if a.Sensitivity == 0 {
+			a.Sensitivity = DEFAULT_ANOMALY_SENSITIVITY
+		} else if a.Sensitivity < 0 {
+			return fmt.Errorf(
+				"anomaly detection sensitivity for %s must be positive",
+				m.Name(),
+			)
+		}
+
+		switch a.Method {
+		case "":
+			a.Method = ANOMALY_METHOD_STDDEV
+		case ANOMALY_METHOD_STDDEV, ANOMALY_METHOD_MAD:
+		default:
+			return fmt.Errorf(
+				"unknown anomaly detection method %q for %s",
+				a.Method,
+				m.Name(),
+			)
+		}
+	}
+
+	return nil
+}
+
+// useAnomalyHistory keeps the anomaly detection history in the state so that
+// it is persisted across runs.
+func useAnomalyHistory(state *State) {
+	if state.Anomalies == nil {
+		state.Anomalies = map[string]*AnomalyHistory{}
+	}
+
+	anomalyHistories = state.Anomalies
+}
+
+// pruneAnomalyHistories drops the histories of series without a data point
+// since the cutoff, such as dimension values that are no longer returned, so
+// that the state does not grow without bound.
+func pruneAnomalyHistories(histories map[string]*AnomalyHistory, cutoff time.Time) {
+	for key, h := range histories {
+		if h.LastTimestamp < cutoff.UnixMilli() {
+			delete(histories, key)
+		}
+	}
+}
+
+func mean(values []float64) float64 {
+	sum := 0.0
+	for _, v := range values {
+		sum += v
+	}
+	return sum / float64(len(values))
+}
+
+func median(values []float64) float64 {
+	sorted := append([]float64{}, values...)
+	sort.Float64s(sorted)
+
+	n := len(sorted)
+	if n % 2 == 1 {
+		return sorted[n / 2]
+	}
+
+	return (sorted[n / 2 - 1] + sorted[n / 2]) / 2
+}
+
+// anomalyScore scores a value against a window of previous values and returns
+// the score and the baseline it was compared to. It returns false when the
+// values do not vary, in which case no score can be computed.
+func anomalyScore(
+	method string,
+	window []float64,
+	value float64,
+) (float64, float64, bool) {
+	if method == ANOMALY_METHOD_MAD {
+		center := median(window)
+
+		deviations := make([]float64, len(window))
+		for i, v := range window {
+			deviations[i] = math.Abs(v - center)
+		}
+
+		mad := median(deviations)
+		if mad == 0 {
+			return 0, center, false
+		}
+
+		return MAD_SCALE * (value - center) / mad, center, true
+	}
+
+	center := mean(window)
+
+	variance := 0.0
+	for _, v := range window {
+		variance += (v - center) * (v - center)
+	}
+
+	stddev := math.Sqrt(variance / float64(len(window)))
+	if stddev == 0 {
+		return 0, center, false
+	}
+
+	return (value - center) / stddev, center, true
+}
+
+// detectAnomalies scores the configured metrics of a data point against their
+// history, adds the point to the history and adds a score metric and an
+// event for each anomaly. Scoring starts once MIN_ANOMALY_WINDOW previous
+// points are available.
+func detectAnomalies(
+	entity     *integration.Entity,
+	q          *query,
+	timestamp  time.Time,
+	dimensions []api.Dimension,
+	attributes []api.Dimension,
+	points     []pointValue,
+) error {
+	a := q.metric.Anomaly
+	if a == nil {
+		return nil
+	}
+
+	dimension := dimensionString(dimensions)
+
+	for _, name := range a.Metrics {
+		for _, p := range points {
+			if p.name != name {
+				continue
+			}
+
+			key := q.key + "|" + name + "|" + dimension
+
+			h, ok := anomalyHistories[key]
+			if !ok {
+				h = &AnomalyHistory{}
+				anomalyHistories[key] = h
+			}
+
+			if timestamp.UnixMilli() <= h.LastTimestamp {
+				continue
+			}
+
+			window := h.Values
+
+			h.LastTimestamp = timestamp.UnixMilli()
+			h.Values = append(h.Values, p.value)
+			if len(h.Values) > a.Window {
+				h.Values = h.Values[len(h.Values) - a.Window:]
+			}
+
+			if len(window) < MIN_ANOMALY_WINDOW {
+				continue
+			}
+
+			score, baseline, ok := anomalyScore(a.Method, window, p.value)
+			if !ok || math.Abs(score) < a.Sensitivity {
+				continue
+			}
+
+			err := addAnomaly(
+				entity,
+				timestamp,
+				a,
+				p,
+				score,
+				baseline,
+				dimension,
+				attributes,
+			)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func addAnomaly(
+	entity     *integration.Entity,
+	timestamp  time.Time,
+	a          *AnomalyConfig,
+	p          pointValue,
+	score      float64,
+	baseline   float64,
+	dimension  string,
+	attributes []api.Dimension,
+) error {
+	metricName := metricMappings.metricName(p.name)
+
+	err := newGaugeMetric(
+		entity,
+		timestamp,
+		ANOMALY_SCORE_METRIC,
+		score,
+		append(
+			attributes[:len(attributes):len(attributes)],
+			api.Dimension{Key: "metric", Value: metricName},
+		),
+	)
+	if err != nil {
+		return err
+	}
+
+	summary := fmt.Sprintf(
+		"%s of %s is anomalous with a score of %s",
+		p.name,
+		strconv.FormatFloat(p.value, 'f', -1, 64),
+		strconv.FormatFloat(score, 'f', 2, 64),
+	)
+	if dimension != "" {
+		summary += " for " + dimension
+	}
+
+	e, err := event.New(timestamp, summary, ANOMALY_EVENT_CATEGORY)
+	if err != nil {
+		return err
+	}
+
+	for _, d := range attributes {
+		err = e.AddAttribute(d.Key, d.Value)
+		if err != nil {
+			return err
+		}
+	}
+
+	anomaly := map[string]interface{}{
+		"metric":      metricName,
+		"dimension":   dimension,
+		"value":       p.value,
+		"score":       score,
+		"baseline":    baseline,
+		"method":      a.Method,
+		"window":      a.Window,
+		"sensitivity": a.Sensitivity,
+	}
+
+	for k, v := range anomaly {
+		err = e.AddAttribute(k, v)
+		if err != nil {
+			return err
+		}
+	}
+
+	entity.AddEvent(e)
+
+	return nil
+}
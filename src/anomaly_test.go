@@ -0,0 +1,195 @@
+package main
+
+import (
+	"math"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/newrelic/nri-conviva/src/api"
+)
+
+func TestPruneAnomalyHistories(t *testing.T) {
+	now := time.Now()
+
+	histories := map[string]*AnomalyHistory{
+		"recent": {LastTimestamp: now.Add(-time.Hour).UnixMilli()},
+		"old":    {LastTimestamp: now.Add(-48 * time.Hour).UnixMilli()},
+	}
+
+	pruneAnomalyHistories(histories, now.Add(-DEFAULT_MAX_LOOKBACK))
+
+	if _, ok := histories["recent"]; !ok {
+		t.Errorf("recent history was pruned")
+	}
+
+	if _, ok := histories["old"]; ok {
+		t.Errorf("old history was not pruned")
+	}
+}
+
+func TestAnomalyScore(t *testing.T) {
+	tests := []struct {
+		method       string
+		window       []float64
+		value        float64
+		wantScore    float64
+		wantBaseline float64
+		wantOk       bool
+	}{
+		{ANOMALY_METHOD_STDDEV, []float64{1, 2, 3}, 5, 3 / math.Sqrt(2.0 / 3), 2, true},
+		{ANOMALY_METHOD_STDDEV, []float64{1, 2, 3}, -1, -3 / math.Sqrt(2.0 / 3), 2, true},
+		{ANOMALY_METHOD_STDDEV, []float64{4, 4, 4}, 10, 0, 4, false},
+		{ANOMALY_METHOD_MAD, []float64{1, 2, 3, 4, 100}, 10, MAD_SCALE * 7, 3, true},
+		{ANOMALY_METHOD_MAD, []float64{1, 2, 3, 4, 100}, -4, -MAD_SCALE * 7, 3, true},
+		{ANOMALY_METHOD_MAD, []float64{5, 5, 5, 9}, 10, 0, 5, false},
+	}
+
+	for _, tt := range tests {
+		score, baseline, ok := anomalyScore(tt.method, tt.window, tt.value)
+		if ok != tt.wantOk ||
+			math.Abs(score - tt.wantScore) > 1e-9 ||
+			baseline != tt.wantBaseline {
+			t.Errorf(
+				"anomalyScore(%s, %v, %v) = %v, %v, %v, want %v, %v, %v",
+				tt.method, tt.window, tt.value,
+				score, baseline, ok,
+				tt.wantScore, tt.wantBaseline, tt.wantOk,
+			)
+		}
+	}
+}
+
+func TestDetectAnomalies(t *testing.T) {
+	histories := anomalyHistories
+	defer func() {
+		anomalyHistories = histories
+	}()
+	anomalyHistories = map[string]*AnomalyHistory{}
+
+	entity := testEntity(t)
+	q := &query{
+		key: "q",
+		metric: &ConfigMetric{
+			Anomaly: &AnomalyConfig{
+				Metrics:     []string{"rebuffering_ratio"},
+				Window:      3,
+				Sensitivity: 3,
+				Method:      ANOMALY_METHOD_STDDEV,
+			},
+		},
+	}
+
+	start := time.Now().Truncate(time.Minute)
+
+	detect := func(minute int, value float64) {
+		err := detectAnomalies(
+			entity,
+			q,
+			start.Add(time.Duration(minute) * time.Minute),
+			nil,
+			nil,
+			[]pointValue{{name: "rebuffering_ratio", value: value}},
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The first MIN_ANOMALY_WINDOW points only fill the window.
+	detect(0, 1)
+	detect(1, 2)
+	detect(2, 100)
+
+	if got := countEvents(entity, ANOMALY_EVENT_CATEGORY); got != 0 {
+		t.Fatalf("got %d anomalies while warming up, want 0", got)
+	}
+
+	detect(3, 3)
+
+	if got := countEvents(entity, ANOMALY_EVENT_CATEGORY); got != 0 {
+		t.Fatalf("got %d anomalies, want 0 for a point within the window", got)
+	}
+
+	// The history keeps the last Window values.
+	h := anomalyHistories["q|rebuffering_ratio|"]
+	if want := []float64{2, 100, 3}; !reflect.DeepEqual(h.Values, want) {
+		t.Errorf("got history %v, want %v", h.Values, want)
+	}
+
+	// Points at or before the last timestamp were already seen.
+	detect(3, 5000)
+	detect(2, 5000)
+
+	if want := []float64{2, 100, 3}; !reflect.DeepEqual(h.Values, want) {
+		t.Errorf("got history %v after old points, want %v", h.Values, want)
+	}
+
+	if got := countEvents(entity, ANOMALY_EVENT_CATEGORY); got != 0 {
+		t.Fatalf("got %d anomalies for old points, want 0", got)
+	}
+
+	detect(4, 1000)
+
+	if got := countEvents(entity, ANOMALY_EVENT_CATEGORY); got != 1 {
+		t.Errorf("got %d anomalies, want 1", got)
+	}
+
+	if got := len(entity.Metrics); got != 1 {
+		t.Errorf("got %d score metrics, want 1", got)
+	}
+
+	if want := []float64{100, 3, 1000}; !reflect.DeepEqual(h.Values, want) {
+		t.Errorf("got history %v, want %v", h.Values, want)
+	}
+}
+
+func TestAnomaliesSkipTotals(t *testing.T) {
+	cfg := &Config{
+		Metrics: []ConfigMetric{{
+			Metric: "rebuffering_ratio",
+			Anomaly: &AnomalyConfig{
+				Metrics: []string{"rebuffering_ratio"},
+			},
+		}},
+	}
+	initTestMetrics(t, cfg)
+
+	entity := testEntity(t)
+	q := &query{key: "q", metric: &cfg.Metrics[0], emitTotals: true}
+	start := time.Now().Add(-time.Hour).Truncate(time.Minute)
+
+	r := &queryResult{
+		metricData: &api.MetricData{
+			Total: api.Total{
+				Metrics: testMetrics(t, 0, `{"rebuffering_ratio": {"ratio": 0.05}}`),
+			},
+			WindowEnd: start.Add(time.Hour),
+		},
+	}
+
+	for i, v := range []string{"0.01", "0.02", "0.01"} {
+		r.metricData.TimeSeries = append(
+			r.metricData.TimeSeries,
+			testMetrics(
+				t,
+				start.Add(time.Duration(i) * time.Minute).UnixMilli(),
+				`{"rebuffering_ratio": {"ratio": ` + v + `}}`,
+			),
+		)
+	}
+
+	if _, _, err := addQueryResult(entity, testLog, q, r); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := len(anomalyHistories); got != 1 {
+		t.Fatalf("got %d anomaly histories, want 1 for the time series", got)
+	}
+
+	for key, h := range anomalyHistories {
+		if len(h.Values) != 3 {
+			t.Errorf("history %s has %d values, want 3", key, len(h.Values))
+		}
+	}
+}
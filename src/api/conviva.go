@@ -5,14 +5,29 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
+	"strconv"
 	"strings"
 	"time"
 )
 
 const (
 	FIFTEEN_MINUTES = 15 * time.Minute
+	SORT_ASC        = "asc"
+	SORT_DESC       = "desc"
 )
 
+// QueryOptions are optional parameters of a query. SortBy and SortOrder sort
+// the dimension values of a dimensional query by a metric and Limit caps the
+// number of dimension values returned. SavedFilter is the ID or name of a
+// saved filter to filter the query by.
+type QueryOptions struct {
+	SortBy      string
+	SortOrder   string
+	Limit       int
+	SavedFilter string
+}
+
 type ConvivaCollector struct {
 	URL             string
 	ClientId        string
@@ -22,6 +37,10 @@ type ConvivaCollector struct {
 	Granularity     string
 	RealTime		*bool
 	log             Logger
+	retryPolicy     *RetryPolicy
+	rateLimiter     *RateLimiter
+	timeRange       *TimeRange
+	savedFilters    *savedFilterCache
 }
 
 func NewConvivaCollector(
@@ -60,57 +79,111 @@ func NewConvivaCollector(
 		Granularity,
 		RealTime,
 		log,
+		DefaultRetryPolicy(),
+		nil,
+		nil,
+		&savedFilterCache{},
 	}, nil
 }
 
+func (c *ConvivaCollector) SetRetryPolicy(p *RetryPolicy) {
+	c.retryPolicy = p
+}
+
+func (c *ConvivaCollector) SetRateLimiter(l *RateLimiter) {
+	c.rateLimiter = l
+}
+
+// SetTimeRange sets an absolute window used by all subsequent queries in
+// place of their start and end offsets. Passing nil restores the offsets.
+func (c *ConvivaCollector) SetTimeRange(r *TimeRange) {
+	c.timeRange = r
+}
+
+func (c *ConvivaCollector) RateLimiterStats() (RateLimiterStats, bool) {
+	if c.rateLimiter == nil {
+		return RateLimiterStats{}, false
+	}
+
+	return c.rateLimiter.Stats(), true
+}
+
 func (c *ConvivaCollector) CollectMetricsByDimension(
 	metricNames []string,
-	dimension string,
+	dimensions []string,
 	filters map[string][]string,
 	startOffset string,
 	endOffset string,
 	granularity string,
 	realTime *bool,
+	options QueryOptions,
 ) (*DimMetricData, error) {
+	path, err := c.makePath(metricNames, "", dimensions)
+	if err != nil {
+		return nil, err
+	}
+
 	url, err := c.makeUrl(
-		c.makePath(metricNames, "", dimension),
+		path,
 		metricNames,
 		filters,
 		startOffset,
 		endOffset,
 		granularity,
 		realTime,
+		options,
 	)
 	if err != nil {
 		return nil, err
 	}
 
-	return c.getMetricDataByDimension(url)
+	metricData, err := c.getMetricDataByDimension(url)
+	if err != nil {
+		return nil, err
+	}
+
+	metricData.WindowEnd = c.windowEnd(startOffset, endOffset)
+
+	return metricData, nil
 }
 
 func (c *ConvivaCollector) CollectMetricGroupByDimension(
 	metricGroup string,
-	dimension string,
+	dimensions []string,
 	filters map[string][]string,
 	startOffset string,
 	endOffset string,
 	granularity string,
 	realTime *bool,
+	options QueryOptions,
 ) (*DimMetricData, error) {
+	path, err := c.makePath(nil, metricGroup, dimensions)
+	if err != nil {
+		return nil, err
+	}
+
 	url, err := c.makeUrl(
-		c.makePath(nil, metricGroup, dimension),
+		path,
 		nil,
 		filters,
 		startOffset,
 		endOffset,
 		granularity,
 		realTime,
+		options,
 	)
 	if err != nil {
 		return nil, err
 	}
 
-	return c.getMetricDataByDimension(url)
+	metricData, err := c.getMetricDataByDimension(url)
+	if err != nil {
+		return nil, err
+	}
+
+	metricData.WindowEnd = c.windowEnd(startOffset, endOffset)
+
+	return metricData, nil
 }
 
 func (c *ConvivaCollector) CollectMetrics(
@@ -120,21 +193,35 @@ func (c *ConvivaCollector) CollectMetrics(
 	endOffset string,
 	granularity string,
 	realTime *bool,
+	options QueryOptions,
 ) (*MetricData, error) {
+	path, err := c.makePath(metricNames, "", nil)
+	if err != nil {
+		return nil, err
+	}
+
 	url, err := c.makeUrl(
-		c.makePath(metricNames, "", ""),
+		path,
 		metricNames,
 		filters,
 		startOffset,
 		endOffset,
 		granularity,
 		realTime,
+		options,
 	)
 	if err != nil {
 		return nil, err
 	}
 
-	return c.getMetricData(url)
+	metricData, err := c.getMetricData(url)
+	if err != nil {
+		return nil, err
+	}
+
+	metricData.WindowEnd = c.windowEnd(startOffset, endOffset)
+
+	return metricData, nil
 }
 
 func (c *ConvivaCollector) CollectMetricGroup(
@@ -144,46 +231,75 @@ func (c *ConvivaCollector) CollectMetricGroup(
 	endOffset string,
 	granularity string,
 	realTime *bool,
+	options QueryOptions,
 ) (*MetricData, error) {
+	path, err := c.makePath(nil, metricGroup, nil)
+	if err != nil {
+		return nil, err
+	}
+
 	url, err := c.makeUrl(
-		c.makePath(nil, metricGroup, ""),
+		path,
 		nil,
 		filters,
 		startOffset,
 		endOffset,
 		granularity,
 		realTime,
+		options,
 	)
 	if err != nil {
 		return nil, err
 	}
 
-	return c.getMetricData(url)
+	metricData, err := c.getMetricData(url)
+	if err != nil {
+		return nil, err
+	}
+
+	metricData.WindowEnd = c.windowEnd(startOffset, endOffset)
+
+	return metricData, nil
 }
 
 func (c ConvivaCollector) makePath(
 	metricNames []string,
 	metricGroup string,
-	dimension string,
-) string {
+	dimensions []string,
+) (string, error) {
 	var (
-		s string
+		segments []string
 		l = len(metricNames)
 	)
 
+	for _, name := range metricNames {
+		if err := ValidateIdentifier("metric", name); err != nil {
+			return "", err
+		}
+	}
+
 	if metricGroup != "" {
-		s = metricGroup
+		if err := ValidateIdentifier("metric group", metricGroup); err != nil {
+			return "", err
+		}
+		segments = append(segments, metricGroup)
 	} else if l > 1 {
-		s = "custom-selection"
+		segments = append(segments, "custom-selection")
 	} else if l == 1 {
-		s = metricNames[0]
+		segments = append(segments, metricNames[0])
 	}
 
-	if dimension != "" {
-		s += fmt.Sprintf("/group-by/%s", dimension)
+	if len(dimensions) > 0 {
+		for _, d := range dimensions {
+			if err := ValidateIdentifier("dimension", d); err != nil {
+				return "", err
+			}
+		}
+
+		segments = append(segments, "group-by", strings.Join(dimensions, ","))
 	}
 
-	return s
+	return strings.Join(segments, "/"), nil
 }
 
 func useRealTime(
@@ -206,6 +322,16 @@ func useRealTime(
 	return true
 }
 
+// apiURL returns the URL of a path relative to the configured API URL.
+func (c ConvivaCollector) apiURL(elem ...string) (*url.URL, error) {
+	u, err := url.Parse(c.URL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid API URL %q: %v", RedactURL(c.URL), err)
+	}
+
+	return u.JoinPath(elem...), nil
+}
+
 func (c ConvivaCollector) makeUrl(
 	path string,
 	metricNames []string,
@@ -214,8 +340,9 @@ func (c ConvivaCollector) makeUrl(
 	endOffset string,
 	granularity string,
 	realTime *bool,
+	options QueryOptions,
 ) (string, error) {
-	var params []string
+	params := url.Values{}
 
 	start, err := getDuration(startOffset, c.StartOffset)
 	if err != nil {
@@ -227,7 +354,12 @@ func (c ConvivaCollector) makeUrl(
 		return "", err
 	}
 
-	if (start != 0) {
+	if c.timeRange != nil {
+		c.log.Debugf("time range: %s", c.timeRange)
+
+		params.Set("start_epoch", strconv.FormatInt(c.timeRange.Start.Unix(), 10))
+		params.Set("end_epoch", strconv.FormatInt(c.timeRange.End.Unix(), 10))
+	} else if (start != 0) {
 		c.log.Debugf("start: %d, end: %d", start, end)
 
 		if end > start {
@@ -238,46 +370,78 @@ func (c ConvivaCollector) makeUrl(
 			)
 		}
 
-		params = addTimeRange(params, start, end)
+		addTimeRange(params, start, end)
 	}
 
-	params = addGranularity(params, granularity, c.Granularity)
+	addGranularity(params, granularity, c.Granularity)
 
 	endpoint := "real-time-metrics"
-	if !useRealTime(start, realTime, c.RealTime) {
+	if c.timeRange != nil || !useRealTime(start, realTime, c.RealTime) {
 		endpoint = "metrics"
 	}
 
-	if len(filters) > 0 {
-		for k, v := range filters {
-			for _, u := range v {
-				params = append(params, fmt.Sprintf("%s=%s", k, u))
-			}
+	for k, v := range filters {
+		if err := ValidateIdentifier("filter dimension", k); err != nil {
+			return "", err
+		}
+
+		for _, u := range v {
+			params.Add(k, u)
 		}
 	}
 
 	if len(metricNames) > 1 {
 		for _, u := range metricNames {
-			params = append(params, "metric=" + u)
+			params.Add("metric", u)
 		}
 	}
 
-	if len(params) == 0 {
-		return fmt.Sprintf(
-			"%s/%s/%s",
-			c.URL,
-			endpoint,
-			path,
-		), nil
+	addSort(params, options)
+
+	if options.SavedFilter != "" {
+		// Resolving a saved filter by name lists the saved filters the
+		// first time it is needed.
+		filterId, err := c.ResolveSavedFilter(options.SavedFilter)
+		if err != nil {
+			return "", err
+		}
+
+		params.Set("filter_id", filterId)
 	}
 
-	return fmt.Sprintf(
-		"%s/%s/%s?%s",
-		c.URL,
-		endpoint,
-		path,
-		strings.Join(params, "&"),
-	), nil
+	u, err := c.apiURL(endpoint, path)
+	if err != nil {
+		return "", err
+	}
+
+	// Encode spaces as %20 rather than + as not every server decodes + in a
+	// query string as a space. A literal + is always encoded as %2B.
+	u.RawQuery = strings.ReplaceAll(params.Encode(), "+", "%20")
+
+	return u.String(), nil
+}
+
+// windowEnd returns the end of the window queried for the given offsets. When
+// no start offset is set Conviva's default range is used, which ends now.
+func (c ConvivaCollector) windowEnd(
+	startOffset string,
+	endOffset string,
+) time.Time {
+	if c.timeRange != nil {
+		return c.timeRange.End
+	}
+
+	start, err := getDuration(startOffset, c.StartOffset)
+	if err != nil || start == 0 {
+		return time.Now()
+	}
+
+	end, err := getDuration(endOffset, c.EndOffset)
+	if err != nil {
+		return time.Now()
+	}
+
+	return time.Now().Add(-end)
 }
 
 func getDuration(offset1 string, offset2 time.Duration) (time.Duration, error) {
@@ -294,35 +458,104 @@ func getDuration(offset1 string, offset2 time.Duration) (time.Duration, error) {
 	return d, nil
 }
 
-func addTimeRange(params []string, start, end time.Duration) []string {
-	params = append(params, fmt.Sprintf(
-		"start_epoch=%d",
-		time.Now().Add(-start).UnixMilli() / 1000,
-	))
-
-	params = append(params, fmt.Sprintf(
-		"end_epoch=%d",
-		time.Now().Add(-end).UnixMilli() / 1000,
-	))
+func addTimeRange(params url.Values, start, end time.Duration) {
+	params.Set(
+		"start_epoch",
+		strconv.FormatInt(time.Now().Add(-start).UnixMilli() / 1000, 10),
+	)
 
-	return params
+	params.Set(
+		"end_epoch",
+		strconv.FormatInt(time.Now().Add(-end).UnixMilli() / 1000, 10),
+	)
 }
 
-func addGranularity(params []string, g1, g2 string) []string {
+func addGranularity(params url.Values, g1, g2 string) {
 	if g1 != "" {
-		return append(params, "granularity=" + g1)
+		params.Set("granularity", g1)
 	} else if g2 != "" {
-		return append(params, "granularity=" + g2)
+		params.Set("granularity", g2)
 	}
+}
+
+func addSort(params url.Values, options QueryOptions) {
+	if options.SortBy != "" {
+		params.Set("sort_by", options.SortBy)
 
-	return params
+		if options.SortOrder != "" {
+			params.Set("sort_order", options.SortOrder)
+		}
+	}
+
+	if options.Limit > 0 {
+		params.Set("limit", strconv.Itoa(options.Limit))
+	}
 }
 
-func (c ConvivaCollector) makeRequest(url string) ([]byte, error) {
+func (c ConvivaCollector) makeRequest(
+	url string,
+	stats *RequestStats,
+) ([]byte, error) {
+	attempt := 1
+
+	for {
+		if c.rateLimiter != nil {
+			if wait := c.rateLimiter.Wait(); wait > 0 {
+				c.log.Debugf(
+					"rate limiter delayed request by %dms",
+					wait.Milliseconds(),
+				)
+				stats.RateLimitWait += wait
+			}
+		}
+
+		then := time.Now()
+		body, err := c.doRequest(url)
+		stats.Requests += 1
+		stats.RequestDuration += time.Since(then)
+
+		if err == nil {
+			stats.ResponseBytes = len(body)
+			return body, nil
+		}
+
+		if c.retryPolicy == nil ||
+			attempt >= c.retryPolicy.MaxAttempts ||
+			!isRetryable(err) {
+			return nil, err
+		}
+
+		backoff, ok := c.retryPolicy.Backoff(attempt, retryAfter(err))
+		if !ok {
+			c.log.Warnf(
+				"not retrying request after attempt %d of %d: server asked to wait %s, more than the maximum backoff of %s",
+				attempt,
+				c.retryPolicy.MaxAttempts,
+				retryAfter(err),
+				c.retryPolicy.MaxBackoff,
+			)
+			return nil, err
+		}
+
+		c.log.Warnf(
+			"request attempt %d of %d failed: %v; retrying in %s",
+			attempt,
+			c.retryPolicy.MaxAttempts,
+			err,
+			backoff,
+		)
+
+		time.Sleep(backoff)
+		stats.RetryWait += backoff
+		attempt += 1
+	}
+}
+
+func (c ConvivaCollector) doRequest(url string) ([]byte, error) {
 	then := time.Now()
 	client := &http.Client{}
 
-	c.log.Debugf("making metrics request using URL %s...", url)
+	c.log.Debugf("making metrics request using URL %s...", RedactURL(url))
 
 	req, err := http.NewRequest("GET", url, nil)
 	if err != nil {
@@ -345,17 +578,23 @@ func (c ConvivaCollector) makeRequest(url string) ([]byte, error) {
 
 	c.log.Debugf(
 		"request for %s took %dms",
-		url,
+		RedactURL(url),
 		time.Since(then).Milliseconds(),
 	)
 
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, newAPIError(resp.StatusCode, url, resp.Header, body)
+	}
+
 	return body, nil
 }
 
 func (c ConvivaCollector) getMetricData(url string) (*MetricData, error) {
-	body, err := c.makeRequest(url)
+	stats := RequestStats{}
+
+	body, err := c.makeRequest(url, &stats)
 	if err != nil {
-		return nil, err
+		return nil, &RequestError{stats, err}
 	}
 
 	then := time.Now()
@@ -364,24 +603,29 @@ func (c ConvivaCollector) getMetricData(url string) (*MetricData, error) {
 	c.log.Debugf("unmarshalling data...")
 
 	err = json.Unmarshal(body, metricData)
+	stats.UnmarshalDuration = time.Since(then)
 	if err != nil {
-		return nil, err
+		return nil, &RequestError{stats, err}
 	}
 
 	c.log.Debugf(
 		"unmarshalling took %dms",
-		time.Since(then).Milliseconds(),
+		stats.UnmarshalDuration.Milliseconds(),
 	)
 
+	metricData.Stats = stats
+
 	return metricData, nil
 }
 
 func (c ConvivaCollector) getMetricDataByDimension(
 	url string,
 )(*DimMetricData, error) {
-	body, err := c.makeRequest(url)
+	stats := RequestStats{}
+
+	body, err := c.makeRequest(url, &stats)
 	if err != nil {
-		return nil, err
+		return nil, &RequestError{stats, err}
 	}
 
 	then := time.Now()
@@ -390,14 +634,17 @@ func (c ConvivaCollector) getMetricDataByDimension(
 	c.log.Debugf("unmarshalling data...")
 
 	err = json.Unmarshal(body, metricData)
+	stats.UnmarshalDuration = time.Since(then)
 	if err != nil {
-		return nil, err
+		return nil, &RequestError{stats, err}
 	}
 
 	c.log.Debugf(
 		"unmarshalling took %dms",
-		time.Since(then).Milliseconds(),
+		stats.UnmarshalDuration.Milliseconds(),
 	)
 
+	metricData.Stats = stats
+
 	return metricData, nil
 }
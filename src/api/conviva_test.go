@@ -0,0 +1,34 @@
+package api
+
+import (
+	"testing"
+)
+
+func TestApiURL(t *testing.T) {
+	tests := []struct {
+		base string
+		want string
+	}{
+		{"https://api.conviva.com/insights/3.0", "https://api.conviva.com/insights/3.0/filters"},
+		{"https://api.conviva.com/insights/3.0/", "https://api.conviva.com/insights/3.0/filters"},
+		{"https://api.conviva.com", "https://api.conviva.com/filters"},
+	}
+
+	for _, tt := range tests {
+		c := ConvivaCollector{URL: tt.base}
+
+		u, err := c.apiURL(FILTERS_PATH)
+		if err != nil {
+			t.Fatalf("apiURL(%q): %v", tt.base, err)
+		}
+
+		if got := u.String(); got != tt.want {
+			t.Errorf("apiURL(%q) = %q, want %q", tt.base, got, tt.want)
+		}
+	}
+
+	c := ConvivaCollector{URL: "://bad"}
+	if _, err := c.apiURL(FILTERS_PATH); err == nil {
+		t.Error("expected an error for an invalid URL")
+	}
+}
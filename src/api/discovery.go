@@ -0,0 +1,115 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+)
+
+// The metadata paths and response schema below have not been confirmed against
+// the Conviva documentation, so listing is experimental.
+const (
+	META_METRICS_PATH       = "metrics/_meta/metric-ids"
+	META_METRIC_GROUPS_PATH = "metrics/_meta/metric-groups"
+	META_DIMENSIONS_PATH    = "metrics/_meta/dimensions"
+)
+
+// CatalogEntry describes a metric, metric group or dimension available from
+// the API. Metrics lists the metrics in a metric group.
+type CatalogEntry struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description,omitempty"`
+	Metrics     []string `json:"metrics,omitempty"`
+}
+
+type catalogEntry struct {
+	Id          string   `json:"id"`
+	Description string   `json:"description"`
+	MetricIds   []string `json:"metric_ids"`
+}
+
+// ListMetrics lists the metrics that can be queried.
+func (c *ConvivaCollector) ListMetrics() ([]CatalogEntry, error) {
+	return c.listCatalog(META_METRICS_PATH, "metric_ids")
+}
+
+// ListMetricGroups lists the metric groups that can be queried.
+func (c *ConvivaCollector) ListMetricGroups() ([]CatalogEntry, error) {
+	return c.listCatalog(META_METRIC_GROUPS_PATH, "metric_groups")
+}
+
+// ListDimensions lists the dimensions that queries can be grouped and
+// filtered by.
+func (c *ConvivaCollector) ListDimensions() ([]CatalogEntry, error) {
+	return c.listCatalog(META_DIMENSIONS_PATH, "dimensions")
+}
+
+func (c *ConvivaCollector) listCatalog(
+	path  string,
+	field string,
+) ([]CatalogEntry, error) {
+	u, err := c.apiURL(path)
+	if err != nil {
+		return nil, err
+	}
+
+	stats := RequestStats{}
+
+	body, err := c.makeRequest(u.String(), &stats)
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := decodeCatalog(body, field)
+	if err != nil {
+		return nil, fmt.Errorf(
+			"failed to decode %s, listing is experimental and may not match the API: %w",
+			path,
+			err,
+		)
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name < entries[j].Name
+	})
+
+	return entries, nil
+}
+
+// decodeCatalog decodes a metadata response, which holds the list of entries
+// in the given field, each with an id, a description and, for metric groups,
+// the metric_ids of the group.
+func decodeCatalog(body []byte, field string) ([]CatalogEntry, error) {
+	var response map[string]json.RawMessage
+
+	if err := json.Unmarshal(body, &response); err != nil {
+		return nil, fmt.Errorf("expected an object with a %s list: %v", field, err)
+	}
+
+	raw, ok := response[field]
+	if !ok {
+		return nil, fmt.Errorf("expected an object with a %s list", field)
+	}
+
+	var list []catalogEntry
+
+	if err := json.Unmarshal(raw, &list); err != nil {
+		return nil, fmt.Errorf("invalid %s list: %v", field, err)
+	}
+
+	entries := make([]CatalogEntry, 0, len(list))
+
+	for i, e := range list {
+		if e.Id == "" {
+			return nil, fmt.Errorf("entry %d of %s has no id", i + 1, field)
+		}
+
+		entries = append(entries, CatalogEntry{
+			Name:        e.Id,
+			Description: e.Description,
+			Metrics:     e.MetricIds,
+		})
+	}
+
+	return entries, nil
+}
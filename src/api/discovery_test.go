@@ -0,0 +1,53 @@
+package api
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// The fixtures follow the schema decodeCatalog expects, which has not been
+// confirmed against a real metadata response.
+func TestDecodeCatalog(t *testing.T) {
+	body := `{"metric_groups": [
+		{"id": "quality-metriclens", "description": "Quality MetricLens", "metric_ids": ["plays", "bitrate"]},
+		{"id": "audience"}
+	]}`
+
+	got, err := decodeCatalog([]byte(body), "metric_groups")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []CatalogEntry{
+		{
+			Name:        "quality-metriclens",
+			Description: "Quality MetricLens",
+			Metrics:     []string{"plays", "bitrate"},
+		},
+		{Name: "audience"},
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestDecodeCatalogErrors(t *testing.T) {
+	tests := []struct {
+		body string
+		want string
+	}{
+		{`["plays", "bitrate"]`, "expected an object with a metric_ids list"},
+		{`{"plays": {"description": "Plays"}}`, "expected an object with a metric_ids list"},
+		{`{"metric_ids": {"plays": "Plays"}}`, "invalid metric_ids list"},
+		{`{"metric_ids": [{"key": "plays"}]}`, "entry 1 of metric_ids has no id"},
+	}
+
+	for _, tt := range tests {
+		_, err := decodeCatalog([]byte(tt.body), "metric_ids")
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("decodeCatalog(%s) error = %v, want %q", tt.body, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package api
+
+import (
+	"encoding/json"
+	"sort"
+)
+
+type MetricKind int
+
+const (
+	COUNT MetricKind = iota
+	GAUGE
+)
+
+// MetricValue is a single value decoded from a metric of unknown shape.
+// Suffix is appended to the metric name, e.g. ".percentage".
+type MetricValue struct {
+	Suffix string
+	Kind   MetricKind
+	Value  float64
+}
+
+var (
+	// primaryFields are value shapes that represent the metric itself and
+	// are emitted under the bare metric name.
+	primaryFields = []string{"value", "ratio", "bps", "fps"}
+)
+
+// UnmarshalJSON decodes the timestamp and keeps the raw JSON of every other
+// field, so that a metric whose value has an unexpected shape does not fail
+// the whole response.
+func (m *Metrics) UnmarshalJSON(data []byte) error {
+	raw := map[string]json.RawMessage{}
+
+	err := json.Unmarshal(data, &raw)
+	if err != nil {
+		return err
+	}
+
+	if timestamp, ok := raw["timestamp"]; ok {
+		err = json.Unmarshal(timestamp, &m.TimeStamp)
+		if err != nil {
+			return err
+		}
+
+		delete(raw, "timestamp")
+	}
+
+	m.Raw = raw
+
+	return nil
+}
+
+// UnmarshalJSON is required because the embedded Metrics would otherwise
+// promote its UnmarshalJSON and the dimensional totals would be dropped.
+func (t *Total) UnmarshalJSON(data []byte) error {
+	var dimensional struct {
+		DimensionalData []DimensionalData `json:"dimensional_data"`
+	}
+
+	err := json.Unmarshal(data, &t.Metrics)
+	if err != nil {
+		return err
+	}
+
+	delete(t.Metrics.Raw, "dimensional_data")
+
+	err = json.Unmarshal(data, &dimensional)
+	if err != nil {
+		return err
+	}
+
+	t.DimensionalData = dimensional.DimensionalData
+
+	return nil
+}
+
+// DecodeMetricValues converts the raw JSON of a metric into values based on
+// its shape. A bare number becomes a gauge. In an object, "count" becomes a
+// count under the bare name, the first of "value", "ratio", "bps" or "fps"
+// becomes a gauge under the bare name (or with its key as a suffix if a count
+// is present), and any other numeric field becomes a gauge suffixed with its
+// key. Non-numeric fields are ignored.
+func DecodeMetricValues(raw json.RawMessage) ([]MetricValue, error) {
+	var number float64
+
+	if err := json.Unmarshal(raw, &number); err == nil {
+		return []MetricValue{{"", GAUGE, number}}, nil
+	}
+
+	fields := map[string]json.RawMessage{}
+
+	err := json.Unmarshal(raw, &fields)
+	if err != nil {
+		return nil, err
+	}
+
+	numbers := map[string]float64{}
+	for k, v := range fields {
+		if err := json.Unmarshal(v, &number); err == nil {
+			numbers[k] = number
+		}
+	}
+
+	values := []MetricValue{}
+	bare := false
+
+	if count, ok := numbers["count"]; ok {
+		values = append(values, MetricValue{"", COUNT, count})
+		delete(numbers, "count")
+		bare = true
+	}
+
+	for _, k := range primaryFields {
+		if v, ok := numbers[k]; ok && !bare {
+			values = append(values, MetricValue{"", GAUGE, v})
+			delete(numbers, k)
+			bare = true
+		}
+	}
+
+	keys := make([]string, 0, len(numbers))
+	for k := range numbers {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		values = append(values, MetricValue{"." + k, GAUGE, numbers[k]})
+	}
+
+	return values, nil
+}
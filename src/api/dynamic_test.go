@@ -0,0 +1,33 @@
+package api
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestMetricsUnmarshalKeepsUnexpectedShapes(t *testing.T) {
+	var m Metrics
+
+	err := json.Unmarshal([]byte(`{
+		"timestamp": {"epoch_ms": 1000, "iso_date": "1970-01-01T00:00:01Z"},
+		"plays": {"count": "n/a"},
+		"bitrate": [1, 2]
+	}`), &m)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if m.TimeStamp.EpochMs != 1000 {
+		t.Errorf("got timestamp %d, want 1000", m.TimeStamp.EpochMs)
+	}
+
+	if _, ok := m.Raw["timestamp"]; ok {
+		t.Errorf("timestamp kept in raw metrics")
+	}
+
+	for _, name := range []string{"plays", "bitrate"} {
+		if _, ok := m.Raw[name]; !ok {
+			t.Errorf("raw metrics are missing %s", name)
+		}
+	}
+}
@@ -0,0 +1,217 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	MAX_ERROR_BODY_LENGTH = 512
+	REDACTED              = "REDACTED"
+)
+
+var (
+	sensitiveParams = []string{
+		"client_id",
+		"client_secret",
+		"api_key",
+		"apikey",
+		"token",
+		"access_token",
+	}
+)
+
+/* Conviva error payload */
+
+type ErrorResponse struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+	Error   string `json:"error"`
+}
+
+/* Base API error */
+
+type APIError struct {
+	StatusCode int
+	URL        string
+	Message    string
+	Payload    *ErrorResponse
+	RetryAfter time.Duration
+}
+
+func (e *APIError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf(
+			"conviva api request to %s failed with status %d",
+			e.URL,
+			e.StatusCode,
+		)
+	}
+
+	return fmt.Sprintf(
+		"conviva api request to %s failed with status %d: %s",
+		e.URL,
+		e.StatusCode,
+		e.Message,
+	)
+}
+
+/* 401 and 403 responses */
+
+type AuthError struct {
+	*APIError
+}
+
+func (e *AuthError) Error() string {
+	return "authentication failed: " + e.APIError.Error()
+}
+
+func (e *AuthError) Unwrap() error {
+	return e.APIError
+}
+
+/* 429 responses */
+
+type RateLimitError struct {
+	*APIError
+}
+
+func (e *RateLimitError) Error() string {
+	return "rate limited: " + e.APIError.Error()
+}
+
+func (e *RateLimitError) Unwrap() error {
+	return e.APIError
+}
+
+/* All other 4xx responses */
+
+type BadRequestError struct {
+	*APIError
+}
+
+func (e *BadRequestError) Error() string {
+	return "bad request: " + e.APIError.Error()
+}
+
+func (e *BadRequestError) Unwrap() error {
+	return e.APIError
+}
+
+/* 5xx responses */
+
+type ServerError struct {
+	*APIError
+}
+
+func (e *ServerError) Error() string {
+	return "server error: " + e.APIError.Error()
+}
+
+func (e *ServerError) Unwrap() error {
+	return e.APIError
+}
+
+/* Failed request with the statistics gathered before it failed */
+
+type RequestError struct {
+	Stats RequestStats
+	Err   error
+}
+
+func (e *RequestError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *RequestError) Unwrap() error {
+	return e.Err
+}
+
+func newAPIError(
+	statusCode int,
+	rawURL string,
+	header http.Header,
+	body []byte,
+) error {
+	apiErr := &APIError{
+		StatusCode: statusCode,
+		URL:        RedactURL(rawURL),
+	}
+
+	if statusCode == http.StatusTooManyRequests ||
+		statusCode == http.StatusServiceUnavailable {
+		apiErr.RetryAfter = parseRetryAfter(header.Get("Retry-After"))
+	}
+
+	payload := &ErrorResponse{}
+	if err := json.Unmarshal(body, payload); err == nil {
+		apiErr.Payload = payload
+		if payload.Message != "" {
+			apiErr.Message = payload.Message
+		} else if payload.Error != "" {
+			apiErr.Message = payload.Error
+		}
+	}
+
+	if apiErr.Message == "" {
+		apiErr.Message = truncate(strings.TrimSpace(string(body)))
+	}
+
+	switch {
+	case statusCode == http.StatusUnauthorized ||
+		statusCode == http.StatusForbidden:
+		return &AuthError{apiErr}
+	case statusCode == http.StatusTooManyRequests:
+		return &RateLimitError{apiErr}
+	case statusCode >= 400 && statusCode < 500:
+		return &BadRequestError{apiErr}
+	case statusCode >= 500:
+		return &ServerError{apiErr}
+	}
+
+	return apiErr
+}
+
+func truncate(s string) string {
+	if len(s) > MAX_ERROR_BODY_LENGTH {
+		return s[:MAX_ERROR_BODY_LENGTH] + "..."
+	}
+	return s
+}
+
+// RedactURL strips any user info and credential-like query parameters from
+// a URL so that it can be safely logged or returned in an error.
+func RedactURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+
+	if u.User != nil {
+		u.User = url.User(REDACTED)
+	}
+
+	if u.RawQuery != "" {
+		q := u.Query()
+		redacted := false
+
+		for k := range q {
+			for _, p := range sensitiveParams {
+				if strings.EqualFold(k, p) {
+					q.Set(k, REDACTED)
+					redacted = true
+				}
+			}
+		}
+
+		if redacted {
+			u.RawQuery = q.Encode()
+		}
+	}
+
+	return u.String()
+}
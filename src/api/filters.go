@@ -0,0 +1,133 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sync"
+)
+
+const (
+	FILTERS_PATH = "filters"
+)
+
+var (
+	savedFilterIdRegex = regexp.MustCompile(`^\d+$`)
+)
+
+// SavedFilter is a filter saved in Conviva Pulse that can be referenced by
+// its ID in a query.
+type SavedFilter struct {
+	Id   string
+	Name string
+}
+
+type savedFilter struct {
+	Id   json.RawMessage `json:"id"`
+	Name string          `json:"name"`
+}
+
+// savedFilterCache caches the saved filters so that they are listed at most
+// once per collector. It is shared by copies of the collector.
+type savedFilterCache struct {
+	mu      sync.Mutex
+	filters []SavedFilter
+}
+
+// SavedFilters lists the saved filters of the account.
+func (c *ConvivaCollector) SavedFilters() ([]SavedFilter, error) {
+	c.savedFilters.mu.Lock()
+	defer c.savedFilters.mu.Unlock()
+
+	if c.savedFilters.filters != nil {
+		return c.savedFilters.filters, nil
+	}
+
+	u, err := c.apiURL(FILTERS_PATH)
+	if err != nil {
+		return nil, err
+	}
+
+	stats := RequestStats{}
+
+	body, err := c.makeRequest(u.String(), &stats)
+	if err != nil {
+		return nil, err
+	}
+
+	filters, err := decodeSavedFilters(body)
+	if err != nil {
+		return nil, err
+	}
+
+	c.savedFilters.filters = filters
+
+	return filters, nil
+}
+
+// decodeSavedFilters accepts either a list of filters or an object with the
+// list in a filters field. IDs may be numbers or strings.
+func decodeSavedFilters(body []byte) ([]SavedFilter, error) {
+	var (
+		list    []savedFilter
+		wrapped struct {
+			Filters []savedFilter `json:"filters"`
+		}
+	)
+
+	if err := json.Unmarshal(body, &list); err != nil {
+		if err = json.Unmarshal(body, &wrapped); err != nil {
+			return nil, err
+		}
+		list = wrapped.Filters
+	}
+
+	filters := make([]SavedFilter, 0, len(list))
+
+	for _, f := range list {
+		var (
+			id     string
+			number json.Number
+		)
+
+		if err := json.Unmarshal(f.Id, &id); err != nil {
+			if err = json.Unmarshal(f.Id, &number); err != nil {
+				return nil, fmt.Errorf(
+					"invalid ID %s for saved filter %q",
+					string(f.Id),
+					f.Name,
+				)
+			}
+			id = number.String()
+		}
+
+		filters = append(filters, SavedFilter{id, f.Name})
+	}
+
+	return filters, nil
+}
+
+// ResolveSavedFilter returns the ID of a saved filter given its ID or its
+// name, listing the saved filters to look up a name.
+func (c *ConvivaCollector) ResolveSavedFilter(nameOrId string) (string, error) {
+	if savedFilterIdRegex.MatchString(nameOrId) {
+		return nameOrId, nil
+	}
+
+	filters, err := c.SavedFilters()
+	if err != nil {
+		return "", fmt.Errorf(
+			"failed to list saved filters to resolve %q: %w",
+			nameOrId,
+			err,
+		)
+	}
+
+	for _, f := range filters {
+		if f.Name == nameOrId {
+			return f.Id, nil
+		}
+	}
+
+	return "", fmt.Errorf("no saved filter named %q", nameOrId)
+}
@@ -0,0 +1,29 @@
+package api
+
+import (
+	"fmt"
+	"regexp"
+)
+
+var (
+	identifierRegex = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9_.:\-]*$`)
+)
+
+// ValidateIdentifier checks that a metric, metric group or dimension name,
+// described by kind in the error, is safe to use in a request path or as a
+// query parameter name.
+func ValidateIdentifier(kind string, name string) error {
+	if name == "" {
+		return fmt.Errorf("%s name must not be empty", kind)
+	}
+
+	if !identifierRegex.MatchString(name) {
+		return fmt.Errorf(
+			"invalid %s name %q: only letters, digits, '_', '-', '.' and ':' are allowed",
+			kind,
+			name,
+		)
+	}
+
+	return nil
+}
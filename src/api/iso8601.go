@@ -0,0 +1,55 @@
+package api
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+var (
+	iso8601DurationRegex = regexp.MustCompile(
+		`^P(?:(\d+)Y)?(?:(\d+)M)?(?:(\d+)W)?(?:(\d+)D)?(?:T(?:(\d+)H)?(?:(\d+)M)?(?:(\d+)S)?)?$`,
+	)
+)
+
+// ParseISO8601Duration parses an ISO 8601 duration such as PT5M or P1D, the
+// format Conviva uses for granularity. Years and months are approximated as
+// 365 and 30 days.
+func ParseISO8601Duration(s string) (time.Duration, error) {
+	match := iso8601DurationRegex.FindStringSubmatch(s)
+	if match == nil || s == "P" || s == "PT" || s[len(s)-1] == 'T' {
+		return 0, fmt.Errorf("invalid ISO 8601 duration %q", s)
+	}
+
+	units := []time.Duration{
+		365 * 24 * time.Hour,
+		30 * 24 * time.Hour,
+		7 * 24 * time.Hour,
+		24 * time.Hour,
+		time.Hour,
+		time.Minute,
+		time.Second,
+	}
+
+	d := time.Duration(0)
+
+	for i, unit := range units {
+		if match[i+1] == "" {
+			continue
+		}
+
+		n, err := strconv.Atoi(match[i+1])
+		if err != nil {
+			return 0, fmt.Errorf("invalid ISO 8601 duration %q: %v", s, err)
+		}
+
+		d += time.Duration(n) * unit
+	}
+
+	if d == 0 {
+		return 0, fmt.Errorf("ISO 8601 duration %q must not be zero", s)
+	}
+
+	return d, nil
+}
@@ -0,0 +1,86 @@
+package api
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+type RateLimiterStats struct {
+	Requests  int
+	Delayed   int
+	TotalWait time.Duration
+	MaxWait   time.Duration
+}
+
+// RateLimiter is a token bucket shared by every request made by a collector.
+// Tokens are replenished at Rate per second up to Burst. A request that finds
+// the bucket empty reserves a future token and sleeps until it is available,
+// so concurrent callers are served in the order they arrive.
+type RateLimiter struct {
+	Rate   float64
+	Burst  int
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+	stats  RateLimiterStats
+}
+
+func NewRateLimiter(rate float64, burst int) (*RateLimiter, error) {
+	if rate <= 0 {
+		return nil, fmt.Errorf("rate limit %f must be greater than 0", rate)
+	}
+
+	if burst < 0 {
+		return nil, fmt.Errorf("rate limit burst %d must not be negative", burst)
+	} else if burst == 0 {
+		burst = 1
+	}
+
+	return &RateLimiter{
+		Rate:   rate,
+		Burst:  burst,
+		tokens: float64(burst),
+		last:   time.Now(),
+	}, nil
+}
+
+// Wait blocks until a request may be made and returns how long it waited.
+func (l *RateLimiter) Wait() time.Duration {
+	l.mu.Lock()
+
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.Rate
+	if l.tokens > float64(l.Burst) {
+		l.tokens = float64(l.Burst)
+	}
+	l.last = now
+	l.tokens -= 1
+
+	wait := time.Duration(0)
+	if l.tokens < 0 {
+		wait = time.Duration(-l.tokens / l.Rate * float64(time.Second))
+		l.stats.Delayed += 1
+	}
+
+	l.stats.Requests += 1
+	l.stats.TotalWait += wait
+	if wait > l.stats.MaxWait {
+		l.stats.MaxWait = wait
+	}
+
+	l.mu.Unlock()
+
+	if wait > 0 {
+		time.Sleep(wait)
+	}
+
+	return wait
+}
+
+func (l *RateLimiter) Stats() RateLimiterStats {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.stats
+}
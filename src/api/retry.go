@@ -0,0 +1,200 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+const (
+	DEFAULT_RETRY_MAX_ATTEMPTS = 3
+	DEFAULT_RETRY_BASE_BACKOFF = time.Second
+	DEFAULT_RETRY_MAX_BACKOFF  = 30 * time.Second
+	DEFAULT_RETRY_JITTER       = 0.2
+)
+
+type RetryPolicy struct {
+	MaxAttempts int
+	BaseBackoff time.Duration
+	MaxBackoff  time.Duration
+	Jitter      float64
+}
+
+func DefaultRetryPolicy() *RetryPolicy {
+	return &RetryPolicy{
+		MaxAttempts: DEFAULT_RETRY_MAX_ATTEMPTS,
+		BaseBackoff: DEFAULT_RETRY_BASE_BACKOFF,
+		MaxBackoff:  DEFAULT_RETRY_MAX_BACKOFF,
+		Jitter:      DEFAULT_RETRY_JITTER,
+	}
+}
+
+func NewRetryPolicy(
+	maxAttempts int,
+	baseBackoff string,
+	maxBackoff string,
+	jitter *float64,
+) (*RetryPolicy, error) {
+	var err error
+
+	p := DefaultRetryPolicy()
+
+	if maxAttempts < 0 {
+		return nil, fmt.Errorf(
+			"retry max attempts %d must not be negative",
+			maxAttempts,
+		)
+	} else if maxAttempts > 0 {
+		p.MaxAttempts = maxAttempts
+	}
+
+	if p.BaseBackoff, err = getDuration(baseBackoff, p.BaseBackoff); err != nil {
+		return nil, err
+	}
+
+	if p.MaxBackoff, err = getDuration(maxBackoff, p.MaxBackoff); err != nil {
+		return nil, err
+	}
+
+	if p.MaxBackoff < p.BaseBackoff {
+		return nil, fmt.Errorf(
+			"retry max backoff %s is less than base backoff %s",
+			p.MaxBackoff,
+			p.BaseBackoff,
+		)
+	}
+
+	if jitter != nil {
+		if *jitter < 0 || *jitter > 1 {
+			return nil, fmt.Errorf(
+				"retry jitter %f must be between 0 and 1",
+				*jitter,
+			)
+		}
+		p.Jitter = *jitter
+	}
+
+	return p, nil
+}
+
+// Backoff returns how long to wait before the given retry attempt (1 being
+// the first retry). A Retry-After hint from the server takes precedence over
+// the computed exponential backoff. It returns false when the server asks
+// for a longer wait than MaxBackoff, in which case the request should not be
+// retried, since retrying sooner would most likely fail again.
+func (p *RetryPolicy) Backoff(
+	attempt    int,
+	retryAfter time.Duration,
+) (time.Duration, bool) {
+	if retryAfter > p.MaxBackoff {
+		return 0, false
+	} else if retryAfter > 0 {
+		return retryAfter, true
+	}
+
+	d := float64(p.BaseBackoff) * math.Pow(2, float64(attempt-1))
+	if d > float64(p.MaxBackoff) {
+		d = float64(p.MaxBackoff)
+	}
+
+	if p.Jitter > 0 {
+		d += d * p.Jitter * (2*rand.Float64() - 1)
+	}
+
+	if d > float64(p.MaxBackoff) {
+		d = float64(p.MaxBackoff)
+	}
+
+	return time.Duration(d), true
+}
+
+// isRetryable reports whether a failed GET can be safely retried. Only
+// transient failures qualify: timeouts, connection errors, rate limiting and
+// gateway/availability errors. Auth and bad request errors, as well as
+// transport errors such as an unsupported URL scheme or a host that does not
+// exist, never succeed on retry.
+func isRetryable(err error) bool {
+	var (
+		apiErr *APIError
+		urlErr *url.Error
+		dnsErr *net.DNSError
+		netErr net.Error
+		opErr  *net.OpError
+	)
+
+	if errors.Is(err, context.Canceled) {
+		return false
+	}
+
+	if errors.As(err, &apiErr) {
+		switch apiErr.StatusCode {
+		case http.StatusRequestTimeout,
+			http.StatusTooManyRequests,
+			http.StatusInternalServerError,
+			http.StatusBadGateway,
+			http.StatusServiceUnavailable,
+			http.StatusGatewayTimeout:
+			return true
+		}
+		return false
+	}
+
+	if !errors.As(err, &urlErr) {
+		return false
+	}
+
+	if errors.As(err, &dnsErr) {
+		return dnsErr.IsTimeout || dnsErr.IsTemporary
+	}
+
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+
+	// A connection that could not be established or was dropped, e.g.
+	// refused or reset, or closed before the response was read.
+	return errors.As(err, &opErr) ||
+		errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+func retryAfter(err error) time.Duration {
+	var apiErr *APIError
+
+	if errors.As(err, &apiErr) {
+		return apiErr.RetryAfter
+	}
+
+	return 0
+}
+
+// parseRetryAfter parses a Retry-After header value given either as a number
+// of seconds or as an HTTP date.
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+
+	if secs, err := strconv.Atoi(value); err == nil {
+		if secs < 0 {
+			return 0
+		}
+		return time.Duration(secs) * time.Second
+	}
+
+	if t, err := http.ParseTime(value); err == nil {
+		if d := time.Until(t); d > 0 {
+			return d
+		}
+	}
+
+	return 0
+}
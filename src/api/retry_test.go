@@ -0,0 +1,177 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestBackoff(t *testing.T) {
+	p := &RetryPolicy{
+		MaxAttempts: 5,
+		BaseBackoff: time.Second,
+		MaxBackoff:  5 * time.Second,
+	}
+
+	tests := []struct {
+		attempt    int
+		retryAfter time.Duration
+		want       time.Duration
+		wantOk     bool
+	}{
+		{1, 0, time.Second, true},
+		{2, 0, 2 * time.Second, true},
+		{3, 0, 4 * time.Second, true},
+		{4, 0, 5 * time.Second, true},
+		{10, 0, 5 * time.Second, true},
+		{1, 3 * time.Second, 3 * time.Second, true},
+		{1, 5 * time.Second, 5 * time.Second, true},
+		{1, time.Hour, 0, false},
+	}
+
+	for _, tt := range tests {
+		got, ok := p.Backoff(tt.attempt, tt.retryAfter)
+		if got != tt.want || ok != tt.wantOk {
+			t.Errorf(
+				"attempt %d, retry after %s: got %s, %v, want %s, %v",
+				tt.attempt,
+				tt.retryAfter,
+				got,
+				ok,
+				tt.want,
+				tt.wantOk,
+			)
+		}
+	}
+}
+
+func TestBackoffJitter(t *testing.T) {
+	p := &RetryPolicy{
+		MaxAttempts: 5,
+		BaseBackoff: time.Second,
+		MaxBackoff:  30 * time.Second,
+		Jitter:      0.2,
+	}
+
+	for i := 0; i < 100; i += 1 {
+		got, ok := p.Backoff(2, 0)
+		if !ok || got < 1600 * time.Millisecond || got > 2400 * time.Millisecond {
+			t.Fatalf("got %s, %v, want between 1.6s and 2.4s", got, ok)
+		}
+	}
+
+	for i := 0; i < 100; i += 1 {
+		if got, _ := p.Backoff(10, 0); got > p.MaxBackoff {
+			t.Fatalf("got %s, more than the maximum backoff", got)
+		}
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	tests := []struct {
+		value string
+		want  time.Duration
+	}{
+		{"", 0},
+		{"0", 0},
+		{"120", 2 * time.Minute},
+		{"-5", 0},
+		{"soon", 0},
+		{"Wed, 21 Oct 2015 07:28:00 GMT", 0},
+	}
+
+	for _, tt := range tests {
+		if got := parseRetryAfter(tt.value); got != tt.want {
+			t.Errorf("%q: got %s, want %s", tt.value, got, tt.want)
+		}
+	}
+
+	future := time.Now().Add(time.Minute).UTC().Format(http.TimeFormat)
+
+	got := parseRetryAfter(future)
+	if got <= 0 || got > time.Minute {
+		t.Errorf("%q: got %s, want up to a minute", future, got)
+	}
+}
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func urlError(err error) error {
+	return &url.Error{Op: "Get", URL: "https://api.conviva.com", Err: err}
+}
+
+func TestIsRetryable(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"rate limited", &RateLimitError{&APIError{StatusCode: 429}}, true},
+		{"unavailable", &ServerError{&APIError{StatusCode: 503}}, true},
+		{"gateway timeout", &ServerError{&APIError{StatusCode: 504}}, true},
+		{"not implemented", &ServerError{&APIError{StatusCode: 501}}, false},
+		{"unauthorized", &AuthError{&APIError{StatusCode: 401}}, false},
+		{"bad request", &BadRequestError{&APIError{StatusCode: 400}}, false},
+		{"timeout", urlError(timeoutError{}), true},
+		{"deadline", urlError(context.DeadlineExceeded), true},
+		{
+			"connection refused",
+			urlError(&net.OpError{
+				Op:  "dial",
+				Net: "tcp",
+				Err: os.NewSyscallError("connect", errors.New("connection refused")),
+			}),
+			true,
+		},
+		{"connection closed", urlError(io.EOF), true},
+		{"unexpected EOF", urlError(io.ErrUnexpectedEOF), true},
+		{
+			"unknown host",
+			urlError(&net.OpError{
+				Op:  "dial",
+				Net: "tcp",
+				Err: &net.DNSError{Err: "no such host", Name: "x", IsNotFound: true},
+			}),
+			false,
+		},
+		{
+			"dns timeout",
+			urlError(&net.OpError{
+				Op:  "dial",
+				Net: "tcp",
+				Err: &net.DNSError{Err: "timeout", Name: "x", IsTimeout: true},
+			}),
+			true,
+		},
+		{"unsupported scheme", urlError(errors.New("unsupported protocol scheme \"ftp\"")), false},
+		{"canceled", urlError(context.Canceled), false},
+		{"other", errors.New("failed"), false},
+	}
+
+	for _, tt := range tests {
+		if got := isRetryable(tt.err); got != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestIsRetryableUnsupportedScheme(t *testing.T) {
+	_, err := http.Get("ftp://example.com")
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+
+	if isRetryable(err) {
+		t.Errorf("%v: got retryable", err)
+	}
+}
@@ -0,0 +1,65 @@
+package api
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	MAX_RANGE_MINUTE_GRANULARITY = 24 * time.Hour
+	MAX_RANGE_HOUR_GRANULARITY   = 30 * 24 * time.Hour
+	MAX_RANGE_DAY_GRANULARITY    = 90 * 24 * time.Hour
+)
+
+// TimeRange is an absolute query window. When set on a collector it replaces
+// the relative start and end offsets and forces the historical metrics
+// endpoint.
+type TimeRange struct {
+	Start time.Time
+	End   time.Time
+}
+
+func (r TimeRange) String() string {
+	return fmt.Sprintf(
+		"%s to %s",
+		r.Start.Format(time.RFC3339),
+		r.End.Format(time.RFC3339),
+	)
+}
+
+// MaxTimeRange returns the widest window Conviva accepts for a historical
+// query at the given granularity.
+func MaxTimeRange(granularity string) (time.Duration, error) {
+	if granularity == "" {
+		return MAX_RANGE_MINUTE_GRANULARITY, nil
+	}
+
+	g, err := ParseISO8601Duration(granularity)
+	if err != nil {
+		return 0, err
+	}
+
+	if g < time.Hour {
+		return MAX_RANGE_MINUTE_GRANULARITY, nil
+	} else if g < 24 * time.Hour {
+		return MAX_RANGE_HOUR_GRANULARITY, nil
+	}
+
+	return MAX_RANGE_DAY_GRANULARITY, nil
+}
+
+// SplitTimeRange splits a window into consecutive chunks no wider than size.
+func SplitTimeRange(r TimeRange, size time.Duration) []TimeRange {
+	chunks := []TimeRange{}
+
+	for start := r.Start; start.Before(r.End); start = start.Add(size) {
+		end := start.Add(size)
+		if end.After(r.End) {
+			end = r.End
+		}
+
+		chunks = append(chunks, TimeRange{start, end})
+	}
+
+	return chunks
+}
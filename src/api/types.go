@@ -1,138 +1,80 @@
 package api
 
-/* Metrics */
+import (
+	"bytes"
+	"encoding/json"
+	"time"
+)
 
-type Count struct {
-	Value int64 `json:"count"`
-}
+/* Metrics Map */
 
-type Gauge struct {
-	Value float64 `json:"value"`
+// Metrics holds the timestamp of a data point and the raw JSON of each of its
+// metrics, which is decoded using the metric registry or by its shape.
+type Metrics struct {
+	TimeStamp TimeStamp                  `json:"timestamp"`
+	Raw       map[string]json.RawMessage `json:"-"`
 }
 
-type Percentage struct {
-	Value float64 `json:"percentage"`
-}
+/* Dimension Key:Value */
 
-type CountPercentage struct {
-	Count
-	Percentage
+type Dimension struct {
+	Key         string `json:"key"`
+	Value       string `json:"value"`
+	Description string `json:"description,omitempty"`
 }
 
-type Ratio struct {
-	Ratio float64 `json:"ratio"`
-}
+/* Dimensional Data Struct */
 
-type Bitrate struct {
-	Bps float64 `json:"bps"`
+type DimensionalData struct {
+	Dimensions []Dimension `json:"dimensions"`
+	Metrics    Metrics     `json:"metrics"`
 }
 
-type EndedPlays struct {
-	Count
-	PerUniqueDevice float64 `json:"per_unique_device"`
-}
+// UnmarshalJSON accepts the dimension values of a group-by either as a single
+// "dimension" object, as returned for a single dimension, or as a list under
+// "dimension" or "dimensions", as returned for multiple dimensions.
+func (d *DimensionalData) UnmarshalJSON(data []byte) error {
+	var raw struct {
+		Dimension  json.RawMessage `json:"dimension"`
+		Dimensions []Dimension     `json:"dimensions"`
+		Metrics    Metrics         `json:"metrics"`
+	}
 
-type MinutesPlayed struct {
-	EndedPlays
-	PerEndedPlay float64 `json:"per_ended_play"`
-}
+	err := json.Unmarshal(data, &raw)
+	if err != nil {
+		return err
+	}
 
-type Framerate struct {
-	Fps float64 `json:"fps"`
-}
+	d.Dimensions = raw.Dimensions
+	d.Metrics = raw.Metrics
 
-/* Metrics Map */
+	dimension := bytes.TrimSpace(raw.Dimension)
+	if len(dimension) == 0 || bytes.Equal(dimension, []byte("null")) {
+		return nil
+	}
 
-type Metrics struct {
-	TimeStamp       TimeStamp         `json:"timestamp"`
-	Abandonment							    *CountPercentage  `json:"abandonment"`
-	AbandonmentWithPreRoll                  *Percentage       `json:"abandonment_with_pre_roll"`
-	AbandonmentWithoutPreRoll               *Percentage       `json:"abandonment_without_pre_roll"`
-	AdActualDuration			            *Gauge            `json:"ad_actual_duration"`
-	AdAttempts                              *Count            `json:"ad_attempts"`
-	AdBitrate                               *Bitrate          `json:"ad_bitrate"`
-    AdCompletedCreativePlays                *Gauge            `json:"ad_completed_creative_plays"`
-	AdConcurrentPlays                       *Count            `json:"ad_concurrent_plays"`
-	AdConnectionInducedRebufferingRatio     *Ratio            `json:"ad_connection_induced_rebuffering_ratio"`
-	AdEndedPlays                            *EndedPlays       `json:"ad_ended_plays"`
-	AdExitBeforeVideoStarts                 *CountPercentage  `json:"exits_before_ad_start"`
-	AdFramerate                             *Framerate        `json:"ad_framerate"`
-    AdMinutesPlayed                         *MinutesPlayed    `json:"ad_minutes_played"`
-	AdPercentageComplete                    *Percentage       `json:"ad_percentage_complete"`
-	AdPlays                                 *CountPercentage  `json:"ad_plays"`
-	AdRebufferingRatio                      *Ratio            `json:"ad_rebuffering_ratio"`
-	AdUniqueDevices                         *Count            `json:"ad_unique_devices"`
-	AdVideoPlaybackFailures                 *CountPercentage  `json:"ad_video_playback_failures"`
-    AdVideoRestartTime                      *Gauge            `json:"ad_video_restart_time"`
-	AdVideoStartFailures                    *CountPercentage  `json:"ad_video_start_failures"`
-	AdVideoStartTime                        *Gauge            `json:"ad_video_start_time"`
-	Attempts                                *Count            `json:"attempts"`
-	AttemptsWithPreRoll                     *CountPercentage  `json:"attempts_with_pre_roll"`
-	AttemptsWithoutPreRoll                  *CountPercentage  `json:"attempts_without_pre_roll"`
-    BadSession                              *CountPercentage  `json:"bad_session"`
-    BadSessionAverageLifePlayingTimeMins    *Gauge            `json:"bad_session_average_life_playing_time_mins"`
-    BadUniqueDevices                        *CountPercentage  `json:"bad_unique_devices"`
-    BadUniqueViewers                        *CountPercentage  `json:"bad_unique_viewers"`
-	Bitrate                                 *Bitrate          `json:"bitrate"`
-	ConcurrentPlays                         *Count            `json:"concurrent_plays"`
-	ConnectionInducedRebufferingRatio       *Ratio            `json:"connection_induced_rebuffering_ratio"`
-	EndedPlays                              *EndedPlays       `json:"ended_plays"`
-	EndedPlaysWithAds                       *CountPercentage  `json:"ended_plays_with_ads"`
-	EndedPlaysWithoutAds                    *CountPercentage  `json:"ended_plays_without_ads"`
-	ExitBeforeVideoStarts                   *CountPercentage  `json:"exit_before_video_starts"`
-	Framerate                               *Framerate        `json:"framerate"`
-    GoodSession                             *Count            `json:"good_session"`
-    GoodSessionAverageLifePlayingTimeMins   *Gauge            `json:"good_session_average_life_playing_time_mins"`
-    GoodUniqueDevices                       *Count            `json:"good_unique_devices"`
-    GoodUniqueViewers                       *Count            `json:"good_unique_viewers"`
-	HighRebuffering                         *CountPercentage  `json:"high_rebuffering"`
-	HighRebufferingWithAds                  *Percentage       `json:"high_rebuffering_with_ads"`
-	HighRebufferingWithoutAds               *Percentage       `json:"high_rebuffering_without_ads"`
-	HighStartupTime                         *CountPercentage  `json:"high_startup_time"`
-	HighStartupTimeWithPreRoll              *Percentage       `json:"high_startup_time_with_pre_roll"`
-	HighStartupTimeWithoutPreRoll           *Percentage       `json:"high_startup_time_without_pre_roll"`
-	IntervalMinutesPlayed                   *Count            `json:"interval_minutes_played"`
-	LowBitrate                              *CountPercentage  `json:"low_bitrate"`
-	LowBitrateWithAds                       *Percentage       `json:"low_bitrate_with_ads"`
-	LowBitrateWithoutAds                    *Percentage       `json:"low_bitrate_without_ads"`
-    MinutesPlayed                           *MinutesPlayed    `json:"minutes_played"`
-    NonZeroCirrEndedPlays                   *CountPercentage  `json:"non_zero_cirr_ended_plays"`
-    PercentageComplete                      *Percentage       `json:"percentage_complete"`
-	Plays                                   *CountPercentage  `json:"plays"`
-	RebufferingRatio                        *Ratio            `json:"rebuffering_ratio"`
-    SpiStreams                              *Count            `json:"spi_streams"`
-    SpiUniqueDevices                        *Count            `json:"spi_unique_devices"`
-    SpiUniqueViewers                        *Count            `json:"spi_unique_viewers"`
-    StreamingPerformanceIndex               *Gauge            `json:"streaming_performance_index"`
-    UniqueDevices                           *Count            `json:"unique_devices"`
-	VideoPlaybackFailures                   *CountPercentage  `json:"video_playback_failures"`
-	VideoPlaybackFailuresBusiness           *CountPercentage  `json:"video_playback_failures_business"`
-	VideoPlaybackFailuresTech               *CountPercentage  `json:"video_playback_failures_tech"`
-	VideoPlaybackFailuresTechWithAds        *Percentage       `json:"video_playback_failures_tech_with_ads"`
-	VideoPlaybackFailuresTechWithoutAds     *Percentage       `json:"video_playback_failures_tech_without_ads"`
-	VideoRestartTime                        *Gauge            `json:"video_restart_time"`
-	VideoStartFailures                      *CountPercentage  `json:"video_start_failures"`
-	VideoStartFailuresBusiness              *CountPercentage  `json:"video_start_failures_business"`
-	VideoStartFailuresTech                  *CountPercentage  `json:"video_start_failures_tech"`
-	VideoStartFailuresTechWithPreRoll       *Percentage       `json:"video_start_failures_tech_with_pre_roll"`
-	VideoStartFailuresTechWithoutPreRoll    *Percentage       `json:"video_start_failures_tech_without_pre_roll"`
-	VideoStartTime                          *Gauge            `json:"video_start_time"`
-    ZeroCirrEndedPlays                      *CountPercentage  `json:"zero_cirr_ended_plays"`
-}
+	if dimension[0] == '[' {
+		var dimensions []Dimension
 
-/* Dimension Key:Value */
+		err = json.Unmarshal(dimension, &dimensions)
+		if err != nil {
+			return err
+		}
 
-type Dimension struct {
-	Key         string `json:"key"`
-	Value       string `json:"value"`
-	Description string `json:"description,omitempty"`
-}
+		d.Dimensions = append(dimensions, d.Dimensions...)
+		return nil
+	}
 
-/* Dimensional Data Struct */
+	var single Dimension
 
-type DimensionalData struct {
-	Dimension Dimension `json:"dimension"`
-	Metrics   Metrics   `json:"metrics"`
+	err = json.Unmarshal(dimension, &single)
+	if err != nil {
+		return err
+	}
+
+	d.Dimensions = append([]Dimension{single}, d.Dimensions...)
+
+	return nil
 }
 
 /* Timestamp */
@@ -153,6 +95,21 @@ type Dimensions struct {
 
 type Total struct {
 	Metrics
+	DimensionalData []DimensionalData `json:"dimensional_data"`
+}
+
+/* Request Statistics */
+
+// RequestStats describes the requests made for a query. RequestDuration is
+// the total duration of every attempt and RetryWait the time spent waiting
+// between attempts.
+type RequestStats struct {
+	Requests          int
+	RequestDuration   time.Duration
+	UnmarshalDuration time.Duration
+	RateLimitWait     time.Duration
+	RetryWait         time.Duration
+	ResponseBytes     int
 }
 
 /* Metric Data Response */
@@ -160,11 +117,15 @@ type Total struct {
 type MetricData struct {
 	TimeSeries []Metrics `json:"time_series"`
 	Total      Total       `json:"total"`
+	Stats      RequestStats `json:"-"`
+	WindowEnd  time.Time   `json:"-"`
 }
 
 type DimMetricData struct {
 	TimeSeries []Dimensions `json:"time_series"`
 	Total      Total       `json:"total"`
+	Stats      RequestStats `json:"-"`
+	WindowEnd  time.Time   `json:"-"`
 }
 
 /* Generic Logger Interface */
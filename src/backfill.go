@@ -0,0 +1,246 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/newrelic/infra-integrations-sdk/v4/integration"
+	sdk_log "github.com/newrelic/infra-integrations-sdk/v4/log"
+	"github.com/newrelic/nri-conviva/src/api"
+)
+
+// BackfillState records the progress of a backfill so that an interrupted
+// backfill resumes with the first chunk that was not published. Queries holds
+// the keys of the queries that were published for the chunk starting at
+// Completed, which are not run again when the chunk is retried.
+type BackfillState struct {
+	Start     time.Time `json:"start"`
+	End       time.Time `json:"end"`
+	Completed time.Time `json:"completed"`
+	Queries   []string  `json:"queries,omitempty"`
+}
+
+func parseBackfillRange(start, end string) (*api.TimeRange, error) {
+	if start == "" || end == "" {
+		return nil, fmt.Errorf(
+			"both backfill_start and backfill_end must be specified",
+		)
+	}
+
+	s, err := time.Parse(time.RFC3339, start)
+	if err != nil {
+		return nil, fmt.Errorf("invalid backfill_start: %v", err)
+	}
+
+	e, err := time.Parse(time.RFC3339, end)
+	if err != nil {
+		return nil, fmt.Errorf("invalid backfill_end: %v", err)
+	}
+
+	if !s.Before(e) {
+		return nil, fmt.Errorf(
+			"backfill_start %s must be before backfill_end %s",
+			start,
+			end,
+		)
+	}
+
+	return &api.TimeRange{Start: s, End: e}, nil
+}
+
+// backfillChunkSize returns the widest chunk that Conviva accepts for every
+// configured query so that all queries share the same chunk boundaries.
+func backfillChunkSize(cfg *Config) (time.Duration, error) {
+	size := time.Duration(0)
+
+	for i := 0; i < len(cfg.Metrics); i += 1 {
+		granularity := cfg.Metrics[i].Granularity
+		if granularity == "" {
+			granularity = cfg.Granularity
+		}
+
+		d, err := api.MaxTimeRange(granularity)
+		if err != nil {
+			return 0, err
+		}
+
+		if size == 0 || d < size {
+			size = d
+		}
+	}
+
+	return size, nil
+}
+
+// pendingQueries returns the queries of a chunk that have not been published
+// yet.
+func pendingQueries(queries []query, published []string) []query {
+	done := map[string]bool{}
+	for _, key := range published {
+		done[key] = true
+	}
+
+	pending := []query{}
+
+	for _, q := range queries {
+		if !done[q.key] {
+			pending = append(pending, q)
+		}
+	}
+
+	return pending
+}
+
+// publishedQueries returns the keys of the queries that were published
+// despite the failure of others.
+func publishedQueries(queries []query, err error) []string {
+	var partial *partialFailureError
+
+	if !errors.As(err, &partial) {
+		return nil
+	}
+
+	published := []string{}
+
+	for _, q := range queries {
+		if !partial.keys[q.key] {
+			published = append(published, q.key)
+		}
+	}
+
+	return published
+}
+
+// runBackfill collects all configured metrics over a fixed absolute time range
+// using the historical metrics endpoint, publishing each chunk as soon as it
+// has been collected. The end of the range is compared as given so that a
+// backfill ending in the future can be resumed, but chunks stop at the
+// current time.
+func runBackfill(
+	i *integration.Integration,
+	log sdk_log.Logger,
+	cfg *Config,
+	state *State,
+) error {
+	timeRange, err := parseBackfillRange(args.BackfillStart, args.BackfillEnd)
+	if err != nil {
+		return err
+	}
+
+	size, err := backfillChunkSize(cfg)
+	if err != nil {
+		return err
+	}
+
+	from := timeRange.Start
+
+	if state == nil {
+		log.Warnf("no stateFile configured, backfill progress will not be saved")
+	} else if b := state.Backfill; b != nil &&
+		b.Start.Equal(timeRange.Start) &&
+		b.End.Equal(timeRange.End) {
+		from = b.Completed
+		log.Infof("resuming backfill from %s", from.Format(time.RFC3339))
+	} else {
+		state.Backfill = &BackfillState{
+			Start:     timeRange.Start,
+			End:       timeRange.End,
+			Completed: timeRange.Start,
+		}
+	}
+
+	// Data for the current minute is incomplete, so a range ending in the
+	// future stops at the start of the minute.
+	end := timeRange.End
+	if now := time.Now().Truncate(time.Minute); end.After(now) {
+		end = now
+	}
+
+	chunks := api.SplitTimeRange(api.TimeRange{Start: from, End: end}, size)
+	if len(chunks) == 0 {
+		log.Infof(
+			"backfill of %s is complete up to %s",
+			timeRange,
+			end.Format(time.RFC3339),
+		)
+		return nil
+	}
+
+	c, err := newCollector(log, cfg)
+	if err != nil {
+		return err
+	}
+
+	defer logRateLimiterStats(c, log)
+
+	for j := 0; j < len(chunks); j += 1 {
+		chunk := chunks[j]
+
+		log.Infof(
+			"backfilling chunk %d of %d: %s...",
+			j + 1,
+			len(chunks),
+			chunk,
+		)
+
+		e, err := entity(i)
+		if err != nil {
+			return err
+		}
+
+		c.SetTimeRange(&chunk)
+
+		queries := planQueries(cfg, nil)
+		if state != nil {
+			queries = pendingQueries(queries, state.Backfill.Queries)
+		}
+
+		collectErr := collectQueries(e, c, log, cfg, nil, queries)
+		if collectErr != nil && cfg.FailFast {
+			return collectErr
+		}
+
+		if err = i.Publish(); err != nil {
+			return err
+		}
+
+		// A chunk with failed queries is not marked complete so that
+		// resuming the backfill retries it, but the queries that were
+		// published are recorded so that they are not emitted twice.
+		if collectErr != nil {
+			if state != nil {
+				state.Backfill.Queries = append(
+					state.Backfill.Queries,
+					publishedQueries(queries, collectErr)...,
+				)
+				if err = state.save(); err != nil {
+					return err
+				}
+			}
+
+			return collectErr
+		}
+
+		if state != nil {
+			state.Backfill.Completed = chunk.End
+			state.Backfill.Queries = nil
+			if err = state.save(); err != nil {
+				return err
+			}
+		}
+	}
+
+	if end.Before(timeRange.End) {
+		log.Infof(
+			"backfill of %s complete up to %s",
+			timeRange,
+			end.Format(time.RFC3339),
+		)
+		return nil
+	}
+
+	log.Infof("backfill of %s complete", timeRange)
+
+	return nil
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseBackfillRangeKeepsFutureEnd(t *testing.T) {
+	end := time.Now().Add(48 * time.Hour).UTC().Truncate(time.Second)
+
+	r, err := parseBackfillRange("2023-03-01T00:00:00Z", end.Format(time.RFC3339))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if !r.End.Equal(end) {
+		t.Errorf("got end %s, want %s", r.End, end)
+	}
+}
+
+func TestPendingAndPublishedQueries(t *testing.T) {
+	queries := []query{{key: "plays"}, {key: "bitrate"}, {key: "attempts"}}
+
+	err := &partialFailureError{
+		failed: 1,
+		total:  3,
+		errs:   []error{errors.New("failed")},
+		keys:   map[string]bool{"bitrate": true},
+	}
+
+	published := publishedQueries(queries, err)
+	if want := []string{"plays", "attempts"}; !reflect.DeepEqual(published, want) {
+		t.Errorf("got published %v, want %v", published, want)
+	}
+
+	pending := pendingQueries(queries, published)
+	if len(pending) != 1 || pending[0].key != "bitrate" {
+		t.Errorf("got pending %v, want bitrate only", pending)
+	}
+
+	if published = publishedQueries(queries, errors.New("other")); published != nil {
+		t.Errorf("got published %v for a non-partial failure", published)
+	}
+}
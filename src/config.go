@@ -1,27 +1,66 @@
 package main
 
 import (
+	"fmt"
 	"io"
 	"os"
+	"sort"
+	"strings"
 
 	"gopkg.in/yaml.v3"
 
 	sdk_log "github.com/newrelic/infra-integrations-sdk/v4/log"
+	"github.com/newrelic/nri-conviva/src/api"
 )
 const (
 	DEFAULT_API_V3_URL = "https://api.conviva.com/insights/3.0"
 )
 
+// DimensionSet is one or more dimensions that are grouped by together in a
+// single query. In YAML it is either a single dimension name or a list of
+// dimension names.
+type DimensionSet []string
+
+func (d *DimensionSet) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		*d = DimensionSet{value.Value}
+		return nil
+	}
+
+	var dimensions []string
+
+	err := value.Decode(&dimensions)
+	if err != nil {
+		return err
+	}
+
+	*d = dimensions
+
+	return nil
+}
+
+func (d DimensionSet) String() string {
+	return strings.Join(d, ",")
+}
+
 type ConfigMetric struct {
 	Metric          string 				`yaml:"metric"`
 	MetricGroup     string 				`yaml:"metricGroup"`
 	Names			[]string			`yaml:"names"`
-	Dimensions      []string			`yaml:"dimensions"`
+	Dimensions      []DimensionSet		`yaml:"dimensions"`
 	Filters			map[string][]string `yaml:"filters"`
+	SavedFilter     string              `yaml:"savedFilter"`
 	StartOffset     string              `yaml:"startOffset"`
 	EndOffset       string              `yaml:"endOffset"`
 	Granularity     string              `yaml:"granularity"`
 	RealTime        *bool				`yaml:"realTime,omitempty"`
+	Interval        string              `yaml:"interval"`
+	EmitTotals      *bool				`yaml:"emitTotals,omitempty"`
+	Attributes      map[string]string   `yaml:"attributes"`
+	Output          string              `yaml:"output"`
+	Anomaly         *AnomalyConfig      `yaml:"anomaly,omitempty"`
+	Sort            *SortConfig         `yaml:"sort,omitempty"`
+	Limit           int                 `yaml:"limit"`
 }
 
 type Config struct {
@@ -30,9 +69,133 @@ type Config struct {
 	EndOffset         string			`yaml:"endOffset"`
 	Granularity       string			`yaml:"granularity"`
 	RealTime          *bool				`yaml:"realTime,omitempty"`
+	RetryMaxAttempts  int				`yaml:"retryMaxAttempts"`
+	RetryBaseBackoff  string			`yaml:"retryBaseBackoff"`
+	RetryMaxBackoff   string			`yaml:"retryMaxBackoff"`
+	RetryJitter       *float64			`yaml:"retryJitter,omitempty"`
+	RateLimit         float64			`yaml:"rateLimit"`
+	RateLimitBurst    int				`yaml:"rateLimitBurst"`
+	Concurrency       int				`yaml:"concurrency"`
+	FailFast          bool				`yaml:"failFast"`
+	StateFile         string			`yaml:"stateFile"`
+	MaxLookback       string			`yaml:"maxLookback"`
+	Interval          string			`yaml:"interval"`
+	EmitTotals        bool				`yaml:"emitTotals"`
+	MetricDefinitions []MetricDefinition `yaml:"metricDefinitions"`
+	Mappings          Mappings          `yaml:"mappings"`
+	Units             UnitsConfig       `yaml:"units"`
+	Derived           []DerivedMetric   `yaml:"derived"`
+	Alerts            []AlertRule       `yaml:"alerts"`
 	Metrics           []ConfigMetric    `yaml:"metrics"`
 }
 
+func (m *ConfigMetric) Name() string {
+	if m.MetricGroup != "" {
+		return m.MetricGroup
+	} else if m.Metric != "" {
+		return m.Metric
+	}
+	return strings.Join(m.Names, ",")
+}
+
+// requestedMetrics returns the response fields of the metrics named in the
+// configuration, or nil for a metric group, which returns every metric in the
+// group.
+func (m *ConfigMetric) requestedMetrics() map[string]bool {
+	if m.MetricGroup != "" {
+		return nil
+	}
+
+	requested := map[string]bool{}
+
+	if m.Metric != "" {
+		requested[fieldName(m.Metric)] = true
+	}
+
+	for _, name := range m.Names {
+		requested[fieldName(name)] = true
+	}
+
+	return requested
+}
+
+// validate checks the names in a metric definition that are used to build
+// requests so that malformed entries are rejected before any request is sent.
+func (m *ConfigMetric) validate() error {
+	var first error
+
+	m.checkNames(func(err error, path ...interface{}) {
+		if first == nil {
+			first = err
+		}
+	})
+
+	return first
+}
+
+// checkNames reports each malformed name in a metric definition along with
+// the path of the field it was found in, as mapping keys and list indexes.
+func (m *ConfigMetric) checkNames(report func(err error, path ...interface{})) {
+	if m.Metric != "" {
+		if err := api.ValidateIdentifier("metric", m.Metric); err != nil {
+			report(err, "metric")
+		}
+	}
+
+	if m.MetricGroup != "" {
+		err := api.ValidateIdentifier("metric group", m.MetricGroup)
+		if err != nil {
+			report(err, "metricGroup")
+		}
+	}
+
+	for i, name := range m.Names {
+		if err := api.ValidateIdentifier("metric", name); err != nil {
+			report(err, "names", i)
+		}
+	}
+
+	for i, dimensions := range m.Dimensions {
+		if len(dimensions) == 0 {
+			report(fmt.Errorf("dimension list must not be empty"), "dimensions", i)
+		}
+
+		for j, d := range dimensions {
+			if err := api.ValidateIdentifier("dimension", d); err != nil {
+				report(err, "dimensions", i, j)
+			}
+		}
+	}
+
+	keys := make([]string, 0, len(m.Filters))
+	for k := range m.Filters {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		if err := api.ValidateIdentifier("filter dimension", k); err != nil {
+			report(err, "filters", k)
+		}
+
+		for i, v := range m.Filters[k] {
+			if v == "" || parseFilterPattern(v).pattern == "" {
+				report(fmt.Errorf("filter %s has an empty value", k), "filters", k, i)
+			}
+		}
+	}
+
+	if err := m.checkClientFilters(); err != nil {
+		report(err, "filters")
+	}
+
+	if m.Sort != nil && m.Sort.Metric != "" {
+		if err := api.ValidateIdentifier("sort metric", m.Sort.Metric); err != nil {
+			report(err, "sort", "metric")
+		}
+	}
+}
+
 func applyDefaults(config *Config) {
 	if config.ApiV3URL == "" {
 		config.ApiV3URL = DEFAULT_API_V3_URL
@@ -68,6 +231,17 @@ func loadConfig(configPath string, log sdk_log.Logger) (*Config, error) {
 
 	applyDefaults(cfg)
 
+	for i := 0; i < len(cfg.Metrics); i += 1 {
+		if err = cfg.Metrics[i].validate(); err != nil {
+			return nil, fmt.Errorf(
+				"invalid metric definition %d (%s): %v",
+				i + 1,
+				cfg.Metrics[i].Name(),
+				err,
+			)
+		}
+	}
+
 	log.Debugf("conviva config loaded")
 	log.Debugf("configuration: %v", *cfg)
 
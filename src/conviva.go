@@ -2,6 +2,7 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"runtime"
 
@@ -9,6 +10,7 @@ import (
 
 	sdk_args "github.com/newrelic/infra-integrations-sdk/v4/args"
 	"github.com/newrelic/infra-integrations-sdk/v4/integration"
+	sdk_log "github.com/newrelic/infra-integrations-sdk/v4/log"
 )
 
 type argumentList struct {
@@ -17,10 +19,18 @@ type argumentList struct {
 	ClientSecret      string `help:"Conviva API client secret"`
 	ConfigPath        string `help:"Path to YAML configuration"`
 	ShowVersion       bool   `default:"false" help:"Print build information and exit"`
+	ShowMetrics       bool   `default:"false" help:"Print the metric registry and exit"`
+	List              string `help:"List the metrics, metricGroups or dimensions available from the Conviva API and exit (experimental)"`
+	ListFormat        string `default:"table" help:"Format of the list printed by -list, either table or json"`
+	Validate          bool   `default:"false" help:"Check the configuration and exit without calling the Conviva API"`
+	BackfillStart     string `help:"Start of a historical backfill as an RFC 3339 timestamp"`
+	BackfillEnd       string `help:"End of a historical backfill as an RFC 3339 timestamp"`
+	Daemon            bool   `default:"false" help:"Keep running and collect each metric on its own interval"`
 }
 
 const (
-	integrationName = "com.newrelic.odp.conviva"
+	integrationName        = "com.newrelic.odp.conviva"
+	partialFailureExitCode = 2
 )
 
 var (
@@ -68,25 +78,106 @@ func main() {
 	}
 	*/
 
-	if args.ConfigPath == "" {
+	if args.ConfigPath == "" && !args.ShowMetrics && args.List == "" {
 		fatalIfErr(fmt.Errorf("no config path specified"))
 	}
 
+	if args.Validate {
+		if args.ConfigPath == "" {
+			fatalIfErr(fmt.Errorf("no config path specified"))
+		}
+
+		fatalIfErr(runValidate(args.ConfigPath))
+		os.Exit(0)
+	}
+
 	cfg, err := loadConfig(args.ConfigPath, log)
 	fatalIfErr(err)
 
+	fatalIfErr(initMetrics(cfg))
+
+	if args.ShowMetrics {
+		printMetricDefinitions(metricRegistry)
+		os.Exit(0)
+	}
+
+	if args.List != "" {
+		fatalIfErr(runList(log, cfg, args.List, args.ListFormat))
+		os.Exit(0)
+	}
+
+	var state *State
+
+	if cfg.StateFile != "" {
+		state, err = loadState(cfg.StateFile, cfg.MaxLookback)
+		fatalIfErr(err)
+
+		useAnomalyHistory(state)
+	}
+
+	if args.BackfillStart != "" || args.BackfillEnd != "" {
+		exitOnError(log, runBackfill(i, log, cfg, state))
+		return
+	}
+
+	if args.Daemon {
+		if len(cfg.Metrics) == 0 {
+			fatalIfErr(fmt.Errorf("no metrics found to collect"))
+		}
+
+		fatalIfErr(runDaemon(i, log, cfg, state))
+		return
+	}
+
+	var collectErr error
+
 	if args.All() || args.HasMetrics() {
 		log.Debugf("conviva metric collection enabled.")
 		if len(cfg.Metrics) > 0 {
-			initMetrics()
-			err = getMetricsData(e, log, cfg)
+			c, err := newCollector(log, cfg)
 			fatalIfErr(err)
+
+			collectErr = getMetricsData(e, c, log, cfg, state)
+			logRateLimiterStats(c, log)
+
+			if !isPartialFailure(collectErr) {
+				fatalIfErr(collectErr)
+			}
 		} else {
 			log.Warnf("No metrics found to collect.")
 		}
 	}
 
 	fatalIfErr(i.Publish())
+
+	if state != nil {
+		fatalIfErr(state.save())
+	}
+
+	exitOnError(log, collectErr)
+}
+
+func isPartialFailure(err error) bool {
+	var partialErr *partialFailureError
+
+	return errors.As(err, &partialErr)
+}
+
+func isTotalFailure(err error) bool {
+	var totalErr *totalFailureError
+
+	return errors.As(err, &totalErr)
+}
+
+// exitOnError exits with the partial failure exit code if some queries failed
+// after the remaining data was published, and fails on any other error.
+func exitOnError(log sdk_log.Logger, err error) {
+	if isPartialFailure(err) {
+		log.Errorf("%v, the remaining data was published", err)
+		os.Exit(partialFailureExitCode)
+	}
+
+	fatalIfErr(err)
 }
 
 func entity(i *integration.Integration) (*integration.Entity, error) {
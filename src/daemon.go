@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/newrelic/infra-integrations-sdk/v4/integration"
+	sdk_log "github.com/newrelic/infra-integrations-sdk/v4/log"
+)
+
+const (
+	DEFAULT_DAEMON_INTERVAL = 15 * time.Minute
+)
+
+// parseInterval parses a collection interval, which must be greater than 0
+// or the daemon would collect continuously.
+func parseInterval(value string) (time.Duration, error) {
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, err
+	}
+
+	if d <= 0 {
+		return 0, fmt.Errorf("interval %s must be greater than 0", d)
+	}
+
+	return d, nil
+}
+
+// metricIntervals returns the collection interval for each metric definition,
+// falling back to the global interval and then to the default.
+func metricIntervals(cfg *Config) ([]time.Duration, error) {
+	var err error
+
+	interval := DEFAULT_DAEMON_INTERVAL
+	if cfg.Interval != "" {
+		if interval, err = parseInterval(cfg.Interval); err != nil {
+			return nil, err
+		}
+	}
+
+	intervals := make([]time.Duration, len(cfg.Metrics))
+
+	for i := 0; i < len(cfg.Metrics); i += 1 {
+		intervals[i] = interval
+
+		if cfg.Metrics[i].Interval != "" {
+			intervals[i], err = parseInterval(cfg.Metrics[i].Interval)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return intervals, nil
+}
+
+// runDaemon keeps the integration running, collecting each metric definition
+// on its own interval and publishing the results of each cycle, until the
+// process is interrupted.
+func runDaemon(
+	i *integration.Integration,
+	log sdk_log.Logger,
+	cfg *Config,
+	state *State,
+) error {
+	intervals, err := metricIntervals(cfg)
+	if err != nil {
+		return err
+	}
+
+	c, err := newCollector(log, cfg)
+	if err != nil {
+		return err
+	}
+
+	defer logRateLimiterStats(c, log)
+
+	ctx, stop := signal.NotifyContext(
+		context.Background(),
+		os.Interrupt,
+		syscall.SIGTERM,
+	)
+	defer stop()
+
+	next := make([]time.Time, len(cfg.Metrics))
+	start := time.Now()
+	for j := range next {
+		next[j] = start
+	}
+
+	log.Infof("running in daemon mode with %d metric definitions", len(cfg.Metrics))
+
+	for {
+		now := time.Now()
+		due := map[int]bool{}
+
+		for j := range next {
+			if !next[j].After(now) {
+				due[j] = true
+			}
+		}
+
+		queries := []query{}
+		for _, q := range planQueries(cfg, state) {
+			if due[q.index] {
+				queries = append(queries, q)
+			}
+		}
+
+		log.Debugf("running %d due queries...", len(queries))
+
+		e, err := entity(i)
+		if err != nil {
+			return err
+		}
+
+		// A cycle in which every query failed is retried on the next one,
+		// unless the credentials were rejected.
+		collectErr := collectQueries(e, c, log, cfg, state, queries)
+		if isTotalFailure(collectErr) && !isFatalQueryError(collectErr) {
+			log.Errorf("%v, retrying on the next cycle", collectErr)
+		} else if isPartialFailure(collectErr) {
+			log.Errorf("%v, publishing the remaining data", collectErr)
+		} else if collectErr != nil {
+			return collectErr
+		}
+
+		if err = i.Publish(); err != nil {
+			return err
+		}
+
+		if state != nil {
+			if err = state.save(); err != nil {
+				return err
+			}
+		}
+
+		// Schedule from the previous due time so intervals do not drift,
+		// skipping any runs that were missed while a cycle ran long.
+		for j := range due {
+			next[j] = next[j].Add(intervals[j])
+			if next[j].Before(now) {
+				next[j] = now.Add(intervals[j])
+			}
+		}
+
+		wakeUp := next[0]
+		for j := range next {
+			if next[j].Before(wakeUp) {
+				wakeUp = next[j]
+			}
+		}
+
+		timer := time.NewTimer(time.Until(wakeUp))
+
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			log.Infof("daemon stopped")
+			return nil
+		case <-timer.C:
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestMetricIntervals(t *testing.T) {
+	cfg := &Config{
+		Interval: "5m",
+		Metrics: []ConfigMetric{
+			{},
+			{Interval: "1m"},
+		},
+	}
+
+	got, err := metricIntervals(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []time.Duration{5 * time.Minute, time.Minute}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	got, err = metricIntervals(&Config{Metrics: []ConfigMetric{{}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got[0] != DEFAULT_DAEMON_INTERVAL {
+		t.Errorf("got %v, want the default interval", got[0])
+	}
+}
+
+func TestMetricIntervalsRejectsNonPositive(t *testing.T) {
+	tests := []*Config{
+		{Interval: "0s", Metrics: []ConfigMetric{{}}},
+		{Interval: "-1m", Metrics: []ConfigMetric{{}}},
+		{Metrics: []ConfigMetric{{Interval: "0s"}}},
+		{Metrics: []ConfigMetric{{Interval: "-5m"}}},
+		{Metrics: []ConfigMetric{{Interval: "soon"}}},
+	}
+
+	for _, cfg := range tests {
+		if _, err := metricIntervals(cfg); err == nil {
+			t.Errorf("expected an error for %+v", cfg)
+		}
+	}
+}
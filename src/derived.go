@@ -0,0 +1,302 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"unicode"
+
+	sdk_log "github.com/newrelic/infra-integrations-sdk/v4/log"
+)
+
+var (
+	errMissingInput  = errors.New("missing input")
+	errDivideByZero  = errors.New("divide by zero")
+	derivedMetrics   = []*derivedMetric{}
+)
+
+// DerivedMetric is a gauge computed from other metrics of the same data point
+// using an arithmetic expression over metric names without the prefix, e.g.
+// video_start_failures / attempts.
+type DerivedMetric struct {
+	Name       string `yaml:"name"`
+	Expression string `yaml:"expression"`
+}
+
+type derivedMetric struct {
+	name string
+	expr expr
+}
+
+type expr interface {
+	eval(values map[string]float64) (float64, error)
+}
+
+type numberExpr float64
+
+type metricExpr string
+
+type binaryExpr struct {
+	op    byte
+	left  expr
+	right expr
+}
+
+type negateExpr struct {
+	operand expr
+}
+
+func (e numberExpr) eval(values map[string]float64) (float64, error) {
+	return float64(e), nil
+}
+
+func (e metricExpr) eval(values map[string]float64) (float64, error) {
+	v, ok := values[string(e)]
+	if !ok {
+		return 0, fmt.Errorf("%w %s", errMissingInput, string(e))
+	}
+	return v, nil
+}
+
+func (e *negateExpr) eval(values map[string]float64) (float64, error) {
+	v, err := e.operand.eval(values)
+	return -v, err
+}
+
+func (e *binaryExpr) eval(values map[string]float64) (float64, error) {
+	l, err := e.left.eval(values)
+	if err != nil {
+		return 0, err
+	}
+
+	r, err := e.right.eval(values)
+	if err != nil {
+		return 0, err
+	}
+
+	switch e.op {
+	case '+':
+		return l + r, nil
+	case '-':
+		return l - r, nil
+	case '*':
+		return l * r, nil
+	}
+
+	if r == 0 {
+		return 0, errDivideByZero
+	}
+
+	return l / r, nil
+}
+
+// exprParser is a recursive descent parser for expressions made of numbers,
+// metric names, the operators + - * / and parentheses.
+type exprParser struct {
+	input string
+	pos   int
+}
+
+func parseExpression(s string) (expr, error) {
+	p := &exprParser{input: s}
+
+	e, err := p.parseSum()
+	if err != nil {
+		return nil, err
+	}
+
+	p.skipSpace()
+	if p.pos < len(p.input) {
+		return nil, p.errorf("unexpected %q", p.input[p.pos])
+	}
+
+	return e, nil
+}
+
+func (p *exprParser) errorf(format string, a ...interface{}) error {
+	return fmt.Errorf(
+		"invalid expression %q at offset %d: %s",
+		p.input,
+		p.pos,
+		fmt.Sprintf(format, a...),
+	)
+}
+
+func (p *exprParser) skipSpace() {
+	for p.pos < len(p.input) && unicode.IsSpace(rune(p.input[p.pos])) {
+		p.pos += 1
+	}
+}
+
+// peek returns the next non-space character, or 0 at the end of the input.
+func (p *exprParser) peek() byte {
+	p.skipSpace()
+	if p.pos < len(p.input) {
+		return p.input[p.pos]
+	}
+	return 0
+}
+
+func (p *exprParser) parseSum() (expr, error) {
+	left, err := p.parseProduct()
+	if err != nil {
+		return nil, err
+	}
+
+	for op := p.peek(); op == '+' || op == '-'; op = p.peek() {
+		p.pos += 1
+
+		right, err := p.parseProduct()
+		if err != nil {
+			return nil, err
+		}
+
+		left = &binaryExpr{op, left, right}
+	}
+
+	return left, nil
+}
+
+func (p *exprParser) parseProduct() (expr, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+
+	for op := p.peek(); op == '*' || op == '/'; op = p.peek() {
+		p.pos += 1
+
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+
+		left = &binaryExpr{op, left, right}
+	}
+
+	return left, nil
+}
+
+func (p *exprParser) parseUnary() (expr, error) {
+	if p.peek() == '-' {
+		p.pos += 1
+
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+
+		return &negateExpr{operand}, nil
+	}
+
+	return p.parseOperand()
+}
+
+func (p *exprParser) parseOperand() (expr, error) {
+	c := p.peek()
+
+	switch {
+	case c == '(':
+		p.pos += 1
+
+		e, err := p.parseSum()
+		if err != nil {
+			return nil, err
+		}
+
+		if p.peek() != ')' {
+			return nil, p.errorf("missing )")
+		}
+		p.pos += 1
+
+		return e, nil
+	case isDigit(c) || c == '.':
+		start := p.pos
+		for p.pos < len(p.input) &&
+			(isDigit(p.input[p.pos]) || p.input[p.pos] == '.') {
+			p.pos += 1
+		}
+
+		v, err := strconv.ParseFloat(p.input[start:p.pos], 64)
+		if err != nil {
+			return nil, p.errorf("invalid number %q", p.input[start:p.pos])
+		}
+
+		return numberExpr(v), nil
+	case isNameStart(c):
+		start := p.pos
+		for p.pos < len(p.input) &&
+			(isNameStart(p.input[p.pos]) ||
+				isDigit(p.input[p.pos]) ||
+				p.input[p.pos] == '.') {
+			p.pos += 1
+		}
+
+		return metricExpr(p.input[start:p.pos]), nil
+	case c == 0:
+		return nil, p.errorf("unexpected end of expression")
+	}
+
+	return nil, p.errorf("unexpected %q", c)
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isNameStart(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func initDerivedMetrics(cfg *Config) error {
+	derived := make([]*derivedMetric, 0, len(cfg.Derived))
+
+	for _, d := range cfg.Derived {
+		if d.Name == "" {
+			return fmt.Errorf("derived metric %q has no name", d.Expression)
+		}
+
+		e, err := parseExpression(d.Expression)
+		if err != nil {
+			return fmt.Errorf("derived metric %s: %v", d.Name, err)
+		}
+
+		derived = append(derived, &derivedMetric{d.Name, e})
+	}
+
+	derivedMetrics = derived
+
+	return nil
+}
+
+// evalDerivedMetrics computes the derived metrics from the values of a data
+// point, in the order they are configured so that a derived metric can use
+// the ones before it. A derived metric with a missing input, a division by
+// zero or a result that is not a finite number is skipped and the reason is
+// logged at the debug level.
+func evalDerivedMetrics(points []pointValue) []pointValue {
+	values := make(map[string]float64, len(points))
+	for _, p := range points {
+		values[p.name] = p.value
+	}
+
+	results := []pointValue{}
+
+	for _, d := range derivedMetrics {
+		v, err := d.expr.eval(values)
+		if err == nil && (math.IsNaN(v) || math.IsInf(v, 0)) {
+			err = fmt.Errorf("result %v is not a finite number", v)
+		}
+
+		if err != nil {
+			sdk_log.Debug("skipping derived metric %s: %v", d.name, err)
+			continue
+		}
+
+		values[d.name] = v
+		results = append(results, pointValue{name: d.name, value: v})
+	}
+
+	return results
+}
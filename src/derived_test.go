@@ -0,0 +1,147 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestParseExpression(t *testing.T) {
+	values := map[string]float64{
+		"plays":            4,
+		"attempts":         8,
+		"plays.percentage": 50,
+	}
+
+	tests := []struct {
+		expression string
+		want       float64
+	}{
+		{"1 + 2 * 3", 7},
+		{"(1 + 2) * 3", 9},
+		{"10 - 4 - 3", 3},
+		{"8 / 4 / 2", 1},
+		{"2 * 3 + 4 * 5", 26},
+		{"-2 * 3", -6},
+		{"2 * -3", -6},
+		{"--2", 2},
+		{"-(1 + 2)", -3},
+		{"((2))", 2},
+		{"1.5 * 2", 3},
+		{".5 + 1", 1.5},
+		{"plays / attempts", 0.5},
+		{"plays.percentage / 100", 0.5},
+		{"  plays*2  ", 8},
+	}
+
+	for _, tt := range tests {
+		e, err := parseExpression(tt.expression)
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", tt.expression, err)
+			continue
+		}
+
+		got, err := e.eval(values)
+		if err != nil {
+			t.Errorf("%q: unexpected eval error: %v", tt.expression, err)
+			continue
+		}
+
+		if got != tt.want {
+			t.Errorf("%q: got %v, want %v", tt.expression, got, tt.want)
+		}
+	}
+}
+
+func TestParseExpressionErrors(t *testing.T) {
+	tests := []struct {
+		expression string
+		want       string
+	}{
+		{"", "unexpected end of expression"},
+		{"1 +", "unexpected end of expression"},
+		{"1..2", "invalid number"},
+		{"1.2.3 * 2", "invalid number"},
+		{"(1 + 2", "missing )"},
+		{"1 + 2)", "unexpected ')'"},
+		{"1 2", "unexpected '2'"},
+		{"plays attempts", "unexpected 'a'"},
+		{"1 $ 2", "unexpected '$'"},
+		{"* 2", "unexpected '*'"},
+	}
+
+	for _, tt := range tests {
+		_, err := parseExpression(tt.expression)
+		if err == nil {
+			t.Errorf("%q: expected an error", tt.expression)
+			continue
+		}
+
+		if !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%q: got error %q, want %q", tt.expression, err, tt.want)
+		}
+	}
+}
+
+func TestEvalErrors(t *testing.T) {
+	values := map[string]float64{"plays": 4, "zero": 0}
+
+	tests := []struct {
+		expression string
+		want       error
+	}{
+		{"plays / zero", errDivideByZero},
+		{"plays / (zero * 2)", errDivideByZero},
+		{"plays / attempts", errMissingInput},
+		{"-attempts", errMissingInput},
+	}
+
+	for _, tt := range tests {
+		e, err := parseExpression(tt.expression)
+		if err != nil {
+			t.Fatalf("%q: unexpected error: %v", tt.expression, err)
+		}
+
+		if _, err = e.eval(values); !errors.Is(err, tt.want) {
+			t.Errorf("%q: got error %v, want %v", tt.expression, err, tt.want)
+		}
+	}
+}
+
+func TestEvalDerivedMetricsSkipsFailures(t *testing.T) {
+	defer func(d []*derivedMetric) { derivedMetrics = d }(derivedMetrics)
+
+	err := initDerivedMetrics(&Config{
+		Derived: []DerivedMetric{
+			{Name: "failure_rate", Expression: "failures / attempts"},
+			{Name: "per_play", Expression: "failures / plays"},
+			{Name: "missing", Expression: "failures / exits"},
+			{Name: "failure_percent", Expression: "failure_rate * 100"},
+			{Name: "skipped_input", Expression: "per_play + 1"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	got := evalDerivedMetrics([]pointValue{
+		{name: "failures", value: 2},
+		{name: "attempts", value: 8},
+		{name: "plays", value: 0},
+	})
+
+	want := []pointValue{
+		{name: "failure_rate", value: 0.25},
+		{name: "failure_percent", value: 25},
+	}
+
+	if len(got) != len(want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("got %v, want %v", got[i], want[i])
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/newrelic/infra-integrations-sdk/v4/data/event"
+	"github.com/newrelic/infra-integrations-sdk/v4/integration"
+	"github.com/newrelic/nri-conviva/src/api"
+)
+
+const (
+	OUTPUT_METRICS        = "metrics"
+	OUTPUT_EVENTS         = "events"
+	SAMPLE_EVENT_CATEGORY = "ConvivaSample"
+	SAMPLE_EVENT_SUMMARY  = "Conviva sample"
+)
+
+func checkOutputs(cfg *Config) error {
+	for i := 0; i < len(cfg.Metrics); i += 1 {
+		m := &cfg.Metrics[i]
+
+		switch m.Output {
+		case "", OUTPUT_METRICS, OUTPUT_EVENTS:
+		default:
+			return fmt.Errorf(
+				"metric definition %s has unknown output %q",
+				m.Name(),
+				m.Output,
+			)
+		}
+	}
+
+	return nil
+}
+
+// addSampleEvent adds a single event for a data point with the dimensions and
+// every value as attributes, which avoids creating a metric time series per
+// dimension value for high-cardinality dimensions.
+func addSampleEvent(
+	entity     *integration.Entity,
+	timestamp  time.Time,
+	dimensions []api.Dimension,
+	points     []pointValue,
+) error {
+	if len(points) == 0 {
+		return nil
+	}
+
+	e, err := event.New(timestamp, SAMPLE_EVENT_SUMMARY, SAMPLE_EVENT_CATEGORY)
+	if err != nil {
+		return err
+	}
+
+	for _, d := range dimensions {
+		err = e.AddAttribute(d.Key, d.Value)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, p := range points {
+		err = e.AddAttribute(metricMappings.rename(p.name), p.value)
+		if err != nil {
+			return err
+		}
+	}
+
+	entity.AddEvent(e)
+
+	return nil
+}
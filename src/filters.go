@@ -0,0 +1,260 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	sdk_log "github.com/newrelic/infra-integrations-sdk/v4/log"
+	"github.com/newrelic/nri-conviva/src/api"
+)
+
+const (
+	FILTER_NOT      = "!"
+	FILTER_WILDCARD = "*"
+	FILTER_ESCAPE   = "\\"
+)
+
+// filterPattern is a single filter value. A value starting with ! excludes
+// matching dimension values and a * in a value matches any characters. A
+// leading \ makes the rest of the value literal.
+type filterPattern struct {
+	pattern  string
+	exclude  bool
+	wildcard bool
+}
+
+// dimensionFilter is a filter that Conviva cannot apply, which is applied to
+// the dimensional data before it is emitted.
+type dimensionFilter struct {
+	key     string
+	include []filterPattern
+	exclude []filterPattern
+}
+
+// dimensionKey returns the form dimension names are compared in, since
+// Conviva spells them with hyphens in requests and with either hyphens or
+// underscores in configurations and responses, e.g. device-name and
+// device_name.
+func dimensionKey(name string) string {
+	return strings.ReplaceAll(name, "-", "_")
+}
+
+func parseFilterPattern(value string) filterPattern {
+	if strings.HasPrefix(value, FILTER_ESCAPE) {
+		return filterPattern{pattern: value[len(FILTER_ESCAPE):]}
+	}
+
+	p := filterPattern{pattern: value}
+
+	if strings.HasPrefix(value, FILTER_NOT) {
+		p.exclude = true
+		p.pattern = value[len(FILTER_NOT):]
+	}
+
+	p.wildcard = strings.Contains(p.pattern, FILTER_WILDCARD)
+
+	return p
+}
+
+func (p filterPattern) matches(value string) bool {
+	if !p.wildcard {
+		return value == p.pattern
+	}
+
+	parts := strings.Split(p.pattern, FILTER_WILDCARD)
+
+	if !strings.HasPrefix(value, parts[0]) {
+		return false
+	}
+	value = value[len(parts[0]):]
+
+	last := parts[len(parts) - 1]
+
+	for _, part := range parts[1:len(parts) - 1] {
+		i := strings.Index(value, part)
+		if i < 0 {
+			return false
+		}
+		value = value[i + len(part):]
+	}
+
+	return strings.HasSuffix(value, last)
+}
+
+// splitFilters splits the configured filters into the filters passed to
+// Conviva, which only supports including exact values, and the filters
+// applied by the integration. Exact values are only passed to Conviva when
+// no wildcard value is included for the same dimension, as Conviva would
+// otherwise drop the dimension values that match the wildcard.
+func splitFilters(
+	filters map[string][]string,
+) (map[string][]string, []dimensionFilter) {
+	server := map[string][]string{}
+	client := []dimensionFilter{}
+
+	keys := make([]string, 0, len(filters))
+	for k := range filters {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		f := dimensionFilter{key: k}
+		literals := []string{}
+		wildcard := false
+
+		for _, v := range filters[k] {
+			p := parseFilterPattern(v)
+
+			if p.exclude {
+				f.exclude = append(f.exclude, p)
+				continue
+			}
+
+			f.include = append(f.include, p)
+
+			if p.wildcard {
+				wildcard = true
+			} else {
+				literals = append(literals, p.pattern)
+			}
+		}
+
+		if !wildcard && len(literals) > 0 {
+			server[k] = literals
+		}
+
+		if wildcard || len(f.exclude) > 0 {
+			client = append(client, f)
+		}
+	}
+
+	return server, client
+}
+
+func (f *dimensionFilter) matches(value string) bool {
+	for _, p := range f.exclude {
+		if p.matches(value) {
+			return false
+		}
+	}
+
+	if len(f.include) == 0 {
+		return true
+	}
+
+	for _, p := range f.include {
+		if p.matches(value) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// checkClientFilters checks that the filters that Conviva cannot apply are
+// only used with queries grouped by the filtered dimension, since the
+// integration can only filter on the dimension values it receives.
+func (m *ConfigMetric) checkClientFilters() error {
+	_, client := splitFilters(m.Filters)
+
+	for _, f := range client {
+		if len(m.Dimensions) == 0 {
+			return fmt.Errorf(
+				"exclusion and wildcard filters on %s require grouping by %s",
+				f.key,
+				f.key,
+			)
+		}
+
+		for _, dimensions := range m.Dimensions {
+			found := false
+
+			for _, d := range dimensions {
+				if dimensionKey(d) == dimensionKey(f.key) {
+					found = true
+					break
+				}
+			}
+
+			if !found {
+				return fmt.Errorf(
+					"exclusion and wildcard filters on %s require every dimension set to include %s, but %s does not",
+					f.key,
+					f.key,
+					dimensions,
+				)
+			}
+		}
+	}
+
+	return nil
+}
+
+// filterDimensionalData returns the dimensional data whose dimension values
+// match all of the filters. Data without a filtered dimension is dropped when
+// the filter includes values and kept when it only excludes values. Either way
+// it is counted in missing by filter key, as the key then likely names a
+// dimension the query is not grouped by.
+func filterDimensionalData(
+	data    []api.DimensionalData,
+	filters []dimensionFilter,
+	missing map[string]int,
+) []api.DimensionalData {
+	if len(filters) == 0 {
+		return data
+	}
+
+	filtered := make([]api.DimensionalData, 0, len(data))
+
+	for _, d := range data {
+		keep := true
+
+		for i := 0; i < len(filters) && keep; i += 1 {
+			found := false
+
+			for _, dimension := range d.Dimensions {
+				if dimensionKey(dimension.Key) == dimensionKey(filters[i].key) {
+					keep = filters[i].matches(dimension.Value)
+					found = true
+					break
+				}
+			}
+
+			if !found {
+				missing[filters[i].key] += 1
+				keep = len(filters[i].include) == 0
+			}
+		}
+
+		if keep {
+			filtered = append(filtered, d)
+		}
+	}
+
+	return filtered
+}
+
+// warnMissingDimensions logs a warning for each filter key that some
+// dimension values of a query had no dimension for.
+func warnMissingDimensions(
+	log     sdk_log.Logger,
+	q       *query,
+	missing map[string]int,
+) {
+	keys := make([]string, 0, len(missing))
+	for k := range missing {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		log.Warnf(
+			"%d dimension values of %s have no %s dimension to filter on",
+			missing[k],
+			q.metric.Name(),
+			k,
+		)
+	}
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/newrelic/nri-conviva/src/api"
+)
+
+func TestFilterPatternMatches(t *testing.T) {
+	tests := []struct {
+		pattern string
+		value   string
+		want    bool
+	}{
+		{"Roku", "Roku", true},
+		{"Roku", "Roku 3", false},
+		{"Samsung*", "Samsung TV", true},
+		{"Samsung*", "Samsung", true},
+		{"Samsung*", "My Samsung", false},
+		{"*TV", "Samsung TV", true},
+		{"*TV", "TV box", false},
+		{"*Roku*", "My Roku 3", true},
+		{"*Roku*", "Roku", true},
+		{"*Roku*", "Rok", false},
+		{"a*b*c", "abc", true},
+		{"a*b*c", "a-b-c", true},
+		{"a*b*c", "acb", false},
+		{"*x*x", "xx", true},
+		{"*x*x", "x", false},
+		{"x*x", "x", false},
+		{"x*x", "xax", true},
+		{"*", "", true},
+		{"*", "anything", true},
+		{"**", "a", true},
+		{"\\!important", "!important", true},
+		{"\\!important", "important", false},
+		{"\\Samsung*", "Samsung*", true},
+		{"\\Samsung*", "Samsung TV", false},
+		{"!QA*", "QA Device", true},
+		{"!QA", "QA", true},
+	}
+
+	for _, tt := range tests {
+		got := parseFilterPattern(tt.pattern).matches(tt.value)
+		if got != tt.want {
+			t.Errorf(
+				"%q matching %q: got %v, want %v",
+				tt.pattern,
+				tt.value,
+				got,
+				tt.want,
+			)
+		}
+	}
+}
+
+func TestParseFilterPattern(t *testing.T) {
+	tests := []struct {
+		value string
+		want  filterPattern
+	}{
+		{"Roku", filterPattern{pattern: "Roku"}},
+		{"!Roku", filterPattern{pattern: "Roku", exclude: true}},
+		{"!", filterPattern{pattern: "", exclude: true}},
+		{"!QA*", filterPattern{pattern: "QA*", exclude: true, wildcard: true}},
+		{"\\!Roku", filterPattern{pattern: "!Roku"}},
+		{"\\*", filterPattern{pattern: "*"}},
+	}
+
+	for _, tt := range tests {
+		got := parseFilterPattern(tt.value)
+		if got != tt.want {
+			t.Errorf("%q: got %+v, want %+v", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestValidateRejectsEmptyFilterValues(t *testing.T) {
+	for _, value := range []string{"", "!", "\\"} {
+		m := &ConfigMetric{
+			Metric:     "plays",
+			Dimensions: []DimensionSet{{"cdn"}},
+			Filters:    map[string][]string{"cdn": {value}},
+		}
+
+		err := m.validate()
+		if err == nil || !strings.Contains(err.Error(), "empty value") {
+			t.Errorf("%q: got %v, want an empty value error", value, err)
+		}
+	}
+}
+
+func TestSplitFilters(t *testing.T) {
+	tests := []struct {
+		name       string
+		filters    map[string][]string
+		wantServer map[string][]string
+		wantClient []dimensionFilter
+	}{
+		{
+			name:       "literals",
+			filters:    map[string][]string{"cdn": {"Akamai", "Level3"}},
+			wantServer: map[string][]string{"cdn": {"Akamai", "Level3"}},
+			wantClient: []dimensionFilter{},
+		},
+		{
+			name:       "literal and exclusion",
+			filters:    map[string][]string{"cdn": {"Akamai", "!Level3"}},
+			wantServer: map[string][]string{"cdn": {"Akamai"}},
+			wantClient: []dimensionFilter{{
+				key:     "cdn",
+				include: []filterPattern{{pattern: "Akamai"}},
+				exclude: []filterPattern{{pattern: "Level3", exclude: true}},
+			}},
+		},
+		{
+			name:       "literal and wildcard",
+			filters:    map[string][]string{"device-name": {"Roku", "Samsung*"}},
+			wantServer: map[string][]string{},
+			wantClient: []dimensionFilter{{
+				key: "device-name",
+				include: []filterPattern{
+					{pattern: "Roku"},
+					{pattern: "Samsung*", wildcard: true},
+				},
+			}},
+		},
+		{
+			name:       "exclusion only",
+			filters:    map[string][]string{"cdn": {"!QA*"}},
+			wantServer: map[string][]string{},
+			wantClient: []dimensionFilter{{
+				key: "cdn",
+				exclude: []filterPattern{
+					{pattern: "QA*", exclude: true, wildcard: true},
+				},
+			}},
+		},
+		{
+			name:       "escaped",
+			filters:    map[string][]string{"cdn": {"\\!Akamai", "\\A*"}},
+			wantServer: map[string][]string{"cdn": {"!Akamai", "A*"}},
+			wantClient: []dimensionFilter{},
+		},
+		{
+			name: "several keys",
+			filters: map[string][]string{
+				"cdn":         {"Akamai"},
+				"device-name": {"!Roku"},
+			},
+			wantServer: map[string][]string{"cdn": {"Akamai"}},
+			wantClient: []dimensionFilter{{
+				key:     "device-name",
+				exclude: []filterPattern{{pattern: "Roku", exclude: true}},
+			}},
+		},
+	}
+
+	for _, tt := range tests {
+		server, client := splitFilters(tt.filters)
+
+		if !reflect.DeepEqual(server, tt.wantServer) {
+			t.Errorf("%s: got server filters %v, want %v", tt.name, server, tt.wantServer)
+		}
+
+		if !reflect.DeepEqual(client, tt.wantClient) {
+			t.Errorf("%s: got client filters %+v, want %+v", tt.name, client, tt.wantClient)
+		}
+	}
+}
+
+func dimensionalData(dimensions ...string) api.DimensionalData {
+	d := api.DimensionalData{}
+
+	for i := 0; i + 1 < len(dimensions); i += 2 {
+		d.Dimensions = append(d.Dimensions, api.Dimension{
+			Key:   dimensions[i],
+			Value: dimensions[i + 1],
+		})
+	}
+
+	return d
+}
+
+func TestFilterDimensionalData(t *testing.T) {
+	data := []api.DimensionalData{
+		dimensionalData("browser_name", "Chrome"),
+		dimensionalData("browser_name", "QA Chrome"),
+		dimensionalData("cdn", "Akamai"),
+	}
+
+	values := func(data []api.DimensionalData) []string {
+		v := []string{}
+		for _, d := range data {
+			v = append(v, d.Dimensions[0].Value)
+		}
+		return v
+	}
+
+	_, exclude := splitFilters(map[string][]string{"browser-name": {"!QA*"}})
+
+	missing := map[string]int{}
+
+	got := values(filterDimensionalData(data, exclude, missing))
+	want := []string{"Chrome", "Akamai"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("exclusion: got %v, want %v", got, want)
+	}
+
+	_, include := splitFilters(map[string][]string{"browser-name": {"*Chrome"}})
+
+	got = values(filterDimensionalData(data, include, missing))
+	want = []string{"Chrome", "QA Chrome"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("wildcard: got %v, want %v", got, want)
+	}
+
+	if want := map[string]int{"browser-name": 2}; !reflect.DeepEqual(missing, want) {
+		t.Errorf("got missing %v, want %v", missing, want)
+	}
+}
+
+func TestCheckClientFiltersNormalizesKeys(t *testing.T) {
+	m := &ConfigMetric{
+		Metric:     "plays",
+		Dimensions: []DimensionSet{{"browser-name"}, {"browser_name", "cdn"}},
+		Filters:    map[string][]string{"browser_name": {"!QA*"}},
+	}
+
+	if err := m.checkClientFilters(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	m.Dimensions = append(m.Dimensions, DimensionSet{"cdn"})
+
+	if err := m.checkClientFilters(); err == nil {
+		t.Errorf("expected an error for a dimension set without browser_name")
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	sdk_log "github.com/newrelic/infra-integrations-sdk/v4/log"
+	"github.com/newrelic/nri-conviva/src/api"
+)
+
+const (
+	LIST_METRICS       = "metrics"
+	LIST_METRIC_GROUPS = "metricGroups"
+	LIST_DIMENSIONS    = "dimensions"
+	LIST_FORMAT_TABLE  = "table"
+	LIST_FORMAT_JSON   = "json"
+)
+
+// runList prints the metrics, metric groups or dimensions available from the
+// Conviva API as a table or as JSON.
+func runList(log sdk_log.Logger, cfg *Config, what, format string) error {
+	if format != LIST_FORMAT_TABLE && format != LIST_FORMAT_JSON {
+		return fmt.Errorf(
+			"unknown list format %q, expected %s or %s",
+			format,
+			LIST_FORMAT_TABLE,
+			LIST_FORMAT_JSON,
+		)
+	}
+
+	c, err := newCollector(log, cfg)
+	if err != nil {
+		return err
+	}
+
+	var entries []api.CatalogEntry
+
+	switch what {
+	case LIST_METRICS:
+		entries, err = c.ListMetrics()
+	case LIST_METRIC_GROUPS:
+		entries, err = c.ListMetricGroups()
+	case LIST_DIMENSIONS:
+		entries, err = c.ListDimensions()
+	default:
+		return fmt.Errorf(
+			"unknown list %q, expected %s, %s or %s",
+			what,
+			LIST_METRICS,
+			LIST_METRIC_GROUPS,
+			LIST_DIMENSIONS,
+		)
+	}
+	if err != nil {
+		return err
+	}
+
+	if format == LIST_FORMAT_JSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(entries)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+
+	if what == LIST_METRIC_GROUPS {
+		fmt.Fprintln(w, "NAME\tDESCRIPTION\tMETRICS")
+	} else {
+		fmt.Fprintln(w, "NAME\tDESCRIPTION")
+	}
+
+	for _, e := range entries {
+		if what == LIST_METRIC_GROUPS {
+			fmt.Fprintf(
+				w,
+				"%s\t%s\t%s\n",
+				e.Name,
+				e.Description,
+				strings.Join(e.Metrics, ","),
+			)
+		} else {
+			fmt.Fprintf(w, "%s\t%s\n", e.Name, e.Description)
+		}
+	}
+
+	return w.Flush()
+}
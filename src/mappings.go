@@ -0,0 +1,91 @@
+package main
+
+import (
+	"sort"
+
+	"github.com/newrelic/nri-conviva/src/api"
+)
+
+// Mappings adapts the names of the emitted metrics and attributes to local
+// naming standards. Prefix replaces METRIC_PREFIX when set, even to the empty
+// string. Metrics renames metrics by their name without the prefix, e.g.
+// plays.percentage, and Dimensions renames dimension keys. Dimension keys in
+// DropDimensions are not emitted at all.
+type Mappings struct {
+	Prefix         *string           `yaml:"prefix,omitempty"`
+	Metrics        map[string]string `yaml:"metrics"`
+	Dimensions     map[string]string `yaml:"dimensions"`
+	DropDimensions []string          `yaml:"dropDimensions"`
+	drop           map[string]bool
+}
+
+var (
+	metricMappings = &Mappings{}
+)
+
+func initMappings(cfg *Config) {
+	m := cfg.Mappings
+
+	m.drop = map[string]bool{}
+	for _, key := range m.DropDimensions {
+		m.drop[key] = true
+	}
+
+	metricMappings = &m
+}
+
+// metricName returns the full name of the New Relic metric for the given
+// metric name without the prefix.
+func (m *Mappings) metricName(name string) string {
+	prefix := METRIC_PREFIX
+	if m.Prefix != nil {
+		prefix = *m.Prefix
+	}
+
+	return prefix + m.rename(name)
+}
+
+// rename returns the new name of a metric without the prefix.
+func (m *Mappings) rename(name string) string {
+	if renamed, ok := m.Metrics[name]; ok {
+		return renamed
+	}
+	return name
+}
+
+// mapDimensions returns the dimensions with renamed keys and without dropped
+// ones. The given slice is never modified.
+func (m *Mappings) mapDimensions(dimensions []api.Dimension) []api.Dimension {
+	mapped := make([]api.Dimension, 0, len(dimensions))
+
+	for _, d := range dimensions {
+		if m.drop[d.Key] {
+			continue
+		}
+
+		if renamed, ok := m.Dimensions[d.Key]; ok {
+			d.Key = renamed
+		}
+
+		mapped = append(mapped, d)
+	}
+
+	return mapped
+}
+
+// staticAttributes returns the static attributes of a metric definition as
+// dimensions, sorted by key so that they are always added in the same order.
+func staticAttributes(m *ConfigMetric) []api.Dimension {
+	keys := make([]string, 0, len(m.Attributes))
+	for k := range m.Attributes {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	attributes := make([]api.Dimension, 0, len(keys))
+	for _, k := range keys {
+		attributes = append(attributes, api.Dimension{Key: k, Value: m.Attributes[k]})
+	}
+
+	return attributes
+}
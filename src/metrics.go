@@ -1,6 +1,7 @@
 package main
 
 import (
+	"errors"
 	"time"
 
 	sdk_metric "github.com/newrelic/infra-integrations-sdk/v4/data/metric"
@@ -12,125 +13,16 @@ import (
 const (
 	METRIC_PREFIX = "conviva."
 	PERCENTAGE_SUFFIX = ".percentage"
+	AGGREGATION_ATTRIBUTE = "conviva.aggregation"
+	AGGREGATION_TOTAL = "total"
 )
 
-type GetCountMetricFunc func (m *api.Metrics) *api.Count
-type GetGaugeMetricFunc func (m *api.Metrics) *api.Gauge
-type GetCountPercentageFunc func (m *api.Metrics) *api.CountPercentage
-
-type AddCountFunc func (metricName string, c int64) error
-type AddGaugeFunc func (metricName string, g float64) error
-type CreateMetricsFunc func (*api.Metrics, AddCountFunc, AddGaugeFunc) error
-
-type AddMetricFunc func(
-	entity        *integration.Entity,
-	timestamp     time.Time,
-	dimension     *api.Dimension,
-	Metrics       *api.Metrics,
-) error
-
-var (
-	metricAdders = []AddMetricFunc{}
-)
-
-func PercentageToGauge(p *api.Percentage) *api.Gauge {
-	if p != nil {
-		return &api.Gauge{Value: p.Value}
-	}
-	return nil
-}
-
-func BitrateToGauge(b *api.Bitrate) *api.Gauge {
-	if b != nil {
-		return &api.Gauge{Value: b.Bps}
-	}
-	return nil
-}
-
-func FramerateToGauge(f *api.Framerate) *api.Gauge {
-	if f != nil {
-		return &api.Gauge{Value: f.Fps}
-	}
-	return nil
-}
-
-func RatioToGauge(r *api.Ratio) *api.Gauge {
-	if r != nil {
-		return &api.Gauge{Value: r.Ratio}
-	}
-	return nil
-}
-
-var addAdEndedPlays CreateMetricsFunc = func (
-	m *api.Metrics,
-	addCount AddCountFunc,
-	addGauge AddGaugeFunc,
-) error {
-	r := m.AdEndedPlays
-	if r != nil {
-		err := addCount("ad_ended_plays", r.Count.Value)
-		if err != nil {
-			return err
-		}
-		return addGauge("ad_ended_plays.per_unique_device", r.PerUniqueDevice)
-	}
-	return nil
-}
-
-var addAdMinutesPlayed CreateMetricsFunc = func (
-	m *api.Metrics,
-	addCount AddCountFunc,
-	addGauge AddGaugeFunc,
-) error {
-	r := m.AdMinutesPlayed
-	if r != nil {
-		err := addCount("ad_minutes_played", r.Count.Value)
-		if err != nil {
-			return err
-		}
-		return addGauge("ad_minutes_played.per_ended_play", r.PerEndedPlay)
-	}
-	return nil
-}
-
-var addEndedPlays CreateMetricsFunc = func (
-	m *api.Metrics,
-	addCount AddCountFunc,
-	addGauge AddGaugeFunc,
-) error {
-	r := m.EndedPlays
-	if r != nil {
-		err := addCount("ended_plays", r.Count.Value)
-		if err != nil {
-			return err
-		}
-		return addGauge("ended_plays.per_unique_device", r.PerUniqueDevice)
-	}
-	return nil
-}
-
-var addMinutesPlayed CreateMetricsFunc = func (
-	m *api.Metrics,
-	addCount AddCountFunc,
-	addGauge AddGaugeFunc,
-) error {
-	r := m.MinutesPlayed
-	if r != nil {
-		err := addCount("minutes_played", r.Count.Value)
-		if err != nil {
-			return err
-		}
-		return addGauge("minutes_played.per_ended_play", r.PerEndedPlay)
-	}
-	return nil
-}
-
 func newMetric(
 	entity *integration.Entity,
-	dimension *api.Dimension,
+	dimensions []api.Dimension,
 	metric sdk_metric.Metric,
 ) error {
-	if dimension != nil {
+	for _, dimension := range dimensions {
 		err := metric.AddDimension(dimension.Key, dimension.Value)
 		if err != nil {
 			return err
@@ -147,18 +39,18 @@ func newCountMetric(
 	timestamp     time.Time,
 	metricName    string,
 	count         int64,
-	dimension     *api.Dimension,
+	dimensions    []api.Dimension,
 ) error {
 	metric, err := sdk_metric.NewCount(
 		timestamp,
-		METRIC_PREFIX + metricName,
+		metricMappings.metricName(metricName),
 		float64(count),
 	)
 	if err != nil {
 		return err
 	}
 
-	return newMetric(entity, dimension, metric)
+	return newMetric(entity, dimensions, metric)
 }
 
 func newGaugeMetric(
@@ -166,576 +58,218 @@ func newGaugeMetric(
 	timestamp     time.Time,
 	metricName    string,
 	value         float64,
-	dimension     *api.Dimension,
+	dimensions    []api.Dimension,
 ) error {
 	metric, err := sdk_metric.NewGauge(
 		timestamp,
-		METRIC_PREFIX + metricName,
+		metricMappings.metricName(metricName),
 		value,
 	)
 	if err != nil {
 		return err
 	}
 
-	return newMetric(entity, dimension, metric)
+	return newMetric(entity, dimensions, metric)
 }
 
-func createCountFunc(metricName string, fn GetCountMetricFunc) AddMetricFunc {
-	return func (
-		e *integration.Entity,
-		t time.Time,
-		d *api.Dimension,
-		m *api.Metrics,
-	) error {
-		c := fn(m)
-		if c != nil {
-			return newCountMetric(
-				e,
-				t,
-				metricName,
-				c.Value,
-				d,
-			)
-		}
+func addMetrics(
+	entity        *integration.Entity,
+	q             *query,
+	metrics       *api.Metrics,
+) (int, error) {
+	return addMetricValues(
+		entity,
+		q,
+		time.UnixMilli(metrics.TimeStamp.EpochMs),
+		nil,
+		metrics,
+		true,
+	)
+}
 
-		return nil
-	}
+func addDimensionalMetrics(
+	entity        *integration.Entity,
+	q             *query,
+	timestamp     time.Time,
+	dimensionData *api.DimensionalData,
+	timeSeries    bool,
+) (int, error) {
+	return addMetricValues(
+		entity,
+		q,
+		timestamp,
+		dimensionData.Dimensions,
+		&dimensionData.Metrics,
+		timeSeries,
+	)
 }
 
-func createGaugeFunc(metricName string, fn GetGaugeMetricFunc) AddMetricFunc {
-	return func (
-		e *integration.Entity,
-		t time.Time,
-		d *api.Dimension,
-		m *api.Metrics,
-	) error {
-		g := fn(m)
-		if g != nil {
-			return newGaugeMetric(
-				e,
-				t,
-				metricName,
-				g.Value,
-				d,
-			)
+// addQueryResult adds the metrics for a query result to the entity, skipping
+// any points at or before the query's high-water mark, and returns the
+// timestamp of the latest point added and the number of data points added,
+// not counting alert and anomaly events and scores.
+func addQueryResult(
+	entity *integration.Entity,
+	log    sdk_log.Logger,
+	q      *query,
+	r      *queryResult,
+) (int64, int, error) {
+	latest := int64(0)
+	dataPoints := 0
+	missing := map[string]int{}
+
+	defer warnMissingDimensions(log, q, missing)
+
+	if r.metricData != nil {
+		metricData := r.metricData
+		for i := 0; i < len(metricData.TimeSeries); i += 1 {
+			epochMs := metricData.TimeSeries[i].TimeStamp.EpochMs
+			if epochMs <= q.since {
+				continue
+			}
+
+			n, err := addMetrics(entity, q, &metricData.TimeSeries[i])
+			if err != nil {
+				return latest, dataPoints, err
+			}
+			dataPoints += n
+
+			if epochMs > latest {
+				latest = epochMs
+			}
 		}
+	} else if r.dimMetricData != nil {
+		metricData := r.dimMetricData
+		for i := 0; i < len(metricData.TimeSeries); i += 1 {
+			dimensions := metricData.TimeSeries[i]
+			if dimensions.TimeStamp.EpochMs <= q.since {
+				continue
+			}
 
-		return nil
-	}
-}
+			ts := time.UnixMilli(dimensions.TimeStamp.EpochMs)
+			dimensionalData := trimDimensionalData(
+				filterDimensionalData(
+					dimensions.DimensionalData,
+					q.clientFilters,
+					missing,
+				),
+				q.metric.Sort,
+				q.metric.Limit,
+			)
 
-func createMetricsFunc(fn CreateMetricsFunc) AddMetricFunc {
-	return func (
-		e *integration.Entity,
-		t time.Time,
-		d *api.Dimension,
-		m *api.Metrics,
-	) error {
-		return fn(
-			m,
-			func (metricName string, c int64) error {
-				return newCountMetric(
-					e,
-					t,
-					metricName,
-					c,
-					d,
+			for j := 0; j < len(dimensionalData); j += 1 {
+				n, err := addDimensionalMetrics(
+					entity,
+					q,
+					ts,
+					&dimensionalData[j],
+					true,
 				)
-			},
-			func (metricName string, g float64) error {
-				return newGaugeMetric(
-					e,
-					t,
-					metricName,
-					g,
-					d,
-				)
-			},
-		)
-	 }
-}
+				if err != nil {
+					return latest, dataPoints, err
+				}
+				dataPoints += n
+			}
 
-func createCountPercentageFunc(
-	metricName string,
-	fn GetCountPercentageFunc,
-) AddMetricFunc {
-	return createMetricsFunc(func (
-		m *api.Metrics,
-		addCount AddCountFunc,
-		addGauge AddGaugeFunc,
-	) error {
-		cp := fn(m)
-		if (cp != nil) {
-			err := addCount(metricName, cp.Count.Value)
-			if err != nil {
-				return err
+			if dimensions.TimeStamp.EpochMs > latest {
+				latest = dimensions.TimeStamp.EpochMs
 			}
+		}
+	}
 
-			return addGauge(metricName + PERCENTAGE_SUFFIX, cp.Percentage.Value)
+	if q.emitTotals {
+		n, err := addTotalMetrics(entity, q, r, missing)
+		if err != nil {
+			return latest, dataPoints, err
 		}
-		return nil
-	})
+		dataPoints += n
+	}
+
+	return latest, dataPoints, nil
 }
 
+// addTotalMetrics adds the metrics in the total block of a query result,
+// timestamped at the end of the query window and marked with an aggregation
+// attribute to distinguish them from the time series, and returns the number
+// of data points added.
+func addTotalMetrics(
+	entity  *integration.Entity,
+	q       *query,
+	r       *queryResult,
+	missing map[string]int,
+) (int, error) {
+	var (
+		total      *api.Total
+		timestamp  time.Time
+		dataPoints int
+	)
+
+	if r.metricData != nil {
+		total = &r.metricData.Total
+		timestamp = r.metricData.WindowEnd
+	} else if r.dimMetricData != nil {
+		total = &r.dimMetricData.Total
+		timestamp = r.dimMetricData.WindowEnd
+	} else {
+		return 0, nil
+	}
 
-func initMetrics() {
-	metricAdders = append(metricAdders, createCountPercentageFunc(
-		"abandonment",
-		func (m *api.Metrics) *api.CountPercentage {
-			return m.Abandonment
-		},
-	))
-	metricAdders = append(metricAdders, createGaugeFunc(
-		"abandonment_with_pre_roll",
-		func (m *api.Metrics) *api.Gauge {
-			return PercentageToGauge(m.AbandonmentWithPreRoll)
-		},
-	))
-	metricAdders = append(metricAdders, createGaugeFunc(
-		"abandonment_without_pre_roll",
-		func (m *api.Metrics) *api.Gauge {
-			return PercentageToGauge(m.AbandonmentWithoutPreRoll)
-		},
-	))
-	metricAdders = append(metricAdders, createGaugeFunc(
-		"ad_actual_duration",
-		func (m *api.Metrics) *api.Gauge {
-			return m.AdActualDuration
-		},
-	))
-	metricAdders = append(metricAdders, createCountFunc(
-		"ad_attempts",
-		func (m *api.Metrics) *api.Count {
-			return m.AdAttempts
-		},
-	))
-	metricAdders = append(metricAdders, createGaugeFunc(
-		"ad_bitrate",
-		func (m *api.Metrics) *api.Gauge {
-			return BitrateToGauge(m.AdBitrate)
-		},
-	))
-	metricAdders = append(metricAdders, createGaugeFunc(
-		"ad_completed_creative_plays",
-		func (m *api.Metrics) *api.Gauge {
-			return m.AdCompletedCreativePlays
-		},
-	))
-	metricAdders = append(metricAdders, createCountFunc(
-		"ad_concurrent_plays",
-		func (m *api.Metrics) *api.Count {
-			return m.AdConcurrentPlays
-		},
-	))
-	metricAdders = append(metricAdders, createGaugeFunc(
-		"ad_connection_induced_rebuffering_ratio",
-		func (m *api.Metrics) *api.Gauge {
-			return RatioToGauge(m.AdConnectionInducedRebufferingRatio)
-		},
-	))
-	metricAdders = append(metricAdders, createMetricsFunc(addAdEndedPlays))
-	metricAdders = append(metricAdders, createCountPercentageFunc(
-		"ad_exit_before_video_starts",
-		func (m *api.Metrics) *api.CountPercentage {
-			return m.AdExitBeforeVideoStarts
-		},
-	))
-	metricAdders = append(metricAdders, createGaugeFunc(
-		"ad_framerate",
-		func (m *api.Metrics) *api.Gauge {
-			return FramerateToGauge(m.AdFramerate)
-		},
-	))
-	metricAdders = append(metricAdders, createMetricsFunc(addAdMinutesPlayed))
-	metricAdders = append(metricAdders, createGaugeFunc(
-		"ad_percentage_complete",
-		func (m *api.Metrics) *api.Gauge {
-			return PercentageToGauge(m.AdPercentageComplete)
-		},
-	))
-	metricAdders = append(metricAdders, createCountPercentageFunc(
-		"ad_plays",
-		func (m *api.Metrics) *api.CountPercentage {
-			return m.AdPlays
-		},
-	))
-	metricAdders = append(metricAdders, createGaugeFunc(
-		"ad_rebuffering_ratio",
-		func (m *api.Metrics) *api.Gauge {
-			return RatioToGauge(m.AdRebufferingRatio)
-		},
-	))
-	metricAdders = append(metricAdders, createCountFunc(
-		"ad_unique_devices",
-		func (m *api.Metrics) *api.Count {
-			return m.AdUniqueDevices
-		},
-	))
-	metricAdders = append(metricAdders, createCountPercentageFunc(
-		"ad_video_playback_failures",
-		func (m *api.Metrics) *api.CountPercentage {
-			return m.AdVideoPlaybackFailures
-		},
-	))
-	metricAdders = append(metricAdders, createGaugeFunc(
-		"ad_video_restart_time",
-		func (m *api.Metrics) *api.Gauge {
-			return m.AdVideoRestartTime
-		},
-	))
-	metricAdders = append(metricAdders, createCountPercentageFunc(
-		"ad_video_start_failures",
-		func (m *api.Metrics) *api.CountPercentage {
-			return m.AdVideoStartFailures
-		},
-	))
-	metricAdders = append(metricAdders, createGaugeFunc(
-		"ad_video_start_time",
-		func (m *api.Metrics) *api.Gauge {
-			return m.AdVideoStartTime
-		},
-	))
-	metricAdders = append(metricAdders, createCountFunc(
-		"attempts",
-		func (m *api.Metrics) *api.Count {
-			return m.Attempts
-		},
-	))
-	metricAdders = append(metricAdders, createCountPercentageFunc(
-		"attempts_with_pre_roll",
-		func (m *api.Metrics) *api.CountPercentage {
-			return m.AttemptsWithPreRoll
-		},
-	))
-	metricAdders = append(metricAdders, createCountPercentageFunc(
-		"attempts_without_pre_roll",
-		func (m *api.Metrics) *api.CountPercentage {
-			return m.AttemptsWithoutPreRoll
-		},
-	))
-	metricAdders = append(metricAdders, createCountPercentageFunc(
-		"bad_session",
-		func (m *api.Metrics) *api.CountPercentage {
-			return m.BadSession
-		},
-	))
-	metricAdders = append(metricAdders, createGaugeFunc(
-		"bad_session_average_life_playing_time_mins",
-		func (m *api.Metrics) *api.Gauge {
-			return m.BadSessionAverageLifePlayingTimeMins
-		},
-	))
-	metricAdders = append(metricAdders, createCountPercentageFunc(
-		"bad_unique_devices",
-		func (m *api.Metrics) *api.CountPercentage {
-			return m.BadUniqueDevices
-		},
-	))
-	metricAdders = append(metricAdders, createCountPercentageFunc(
-		"bad_unique_viewers",
-		func (m *api.Metrics) *api.CountPercentage {
-			return m.BadUniqueViewers
-		},
-	))
-	metricAdders = append(metricAdders, createGaugeFunc(
-		"bitrate",
-		func (m *api.Metrics) *api.Gauge {
-			return BitrateToGauge(m.Bitrate)
-		},
-	))
-	metricAdders = append(metricAdders, createCountFunc(
-		"concurrent_plays",
-		func (m *api.Metrics) *api.Count {
-			return m.ConcurrentPlays
-		},
-	))
-	metricAdders = append(metricAdders, createGaugeFunc(
-		"connection_induced_rebuffering_ratio",
-		func (m *api.Metrics) *api.Gauge {
-			return RatioToGauge(m.ConnectionInducedRebufferingRatio)
-		},
-	))
-	metricAdders = append(metricAdders, createMetricsFunc(addEndedPlays))
-	metricAdders = append(metricAdders, createCountPercentageFunc(
-		"ended_plays_with_ads",
-		func (m *api.Metrics) *api.CountPercentage {
-			return m.EndedPlaysWithAds
-		},
-	))
-	metricAdders = append(metricAdders, createCountPercentageFunc(
-		"ended_plays_without_ads",
-		func (m *api.Metrics) *api.CountPercentage {
-			return m.EndedPlaysWithoutAds
-		},
-	))
-	metricAdders = append(metricAdders, createCountPercentageFunc(
-		"exit_before_video_starts",
-		func (m *api.Metrics) *api.CountPercentage {
-			return m.ExitBeforeVideoStarts
-		},
-	))
-	metricAdders = append(metricAdders, createGaugeFunc(
-		"framerate",
-		func (m *api.Metrics) *api.Gauge {
-			return FramerateToGauge(m.Framerate)
-		},
-	))
-	metricAdders = append(metricAdders, createCountFunc(
-		"good_session",
-		func (m *api.Metrics) *api.Count {
-			return m.GoodSession
-		},
-	))
-	metricAdders = append(metricAdders, createGaugeFunc(
-		"good_session_average_life_playing_time_mins",
-		func (m *api.Metrics) *api.Gauge {
-			return m.GoodSessionAverageLifePlayingTimeMins
-		},
-	))
-	metricAdders = append(metricAdders, createCountFunc(
-		"good_unique_devices",
-		func (m *api.Metrics) *api.Count {
-			return m.GoodUniqueDevices
-		},
-	))
-	metricAdders = append(metricAdders, createCountFunc(
-		"good_unique_viewers",
-		func (m *api.Metrics) *api.Count {
-			return m.GoodUniqueViewers
-		},
-	))
-	metricAdders = append(metricAdders, createCountPercentageFunc(
-		"high_rebuffering",
-		func (m *api.Metrics) *api.CountPercentage {
-			return m.HighRebuffering
-		},
-	))
-	metricAdders = append(metricAdders, createGaugeFunc(
-		"high_rebuffering_with_ads",
-		func (m *api.Metrics) *api.Gauge {
-			return PercentageToGauge(m.HighRebufferingWithAds)
-		},
-	))
-	metricAdders = append(metricAdders, createGaugeFunc(
-		"high_rebuffering_without_ads",
-		func (m *api.Metrics) *api.Gauge {
-			return PercentageToGauge(m.HighRebufferingWithoutAds)
-		},
-	))
-	metricAdders = append(metricAdders, createCountPercentageFunc(
-		"high_startup_time",
-		func (m *api.Metrics) *api.CountPercentage {
-			return m.HighStartupTime
-		},
-	))
-	metricAdders = append(metricAdders, createGaugeFunc(
-		"high_startup_time_with_pre_roll",
-		func (m *api.Metrics) *api.Gauge {
-			return PercentageToGauge(m.HighStartupTimeWithPreRoll)
-		},
-	))
-	metricAdders = append(metricAdders, createGaugeFunc(
-		"high_startup_time_without_pre_roll",
-		func (m *api.Metrics) *api.Gauge {
-			return PercentageToGauge(m.HighStartupTimeWithoutPreRoll)
-		},
-	))
-	metricAdders = append(metricAdders, createCountFunc(
-		"interval_minutes_played",
-		func (m *api.Metrics) *api.Count {
-			return m.IntervalMinutesPlayed
-		},
-	))
-	metricAdders = append(metricAdders, createCountPercentageFunc(
-		"low_bitrate",
-		func (m *api.Metrics) *api.CountPercentage {
-			return m.LowBitrate
-		},
-	))
-	metricAdders = append(metricAdders, createGaugeFunc(
-		"low_bitrate_with_ads",
-		func (m *api.Metrics) *api.Gauge {
-			return PercentageToGauge(m.LowBitrateWithAds)
-		},
-	))
-	metricAdders = append(metricAdders, createGaugeFunc(
-		"low_bitrate_without_ads",
-		func (m *api.Metrics) *api.Gauge {
-			return PercentageToGauge(m.LowBitrateWithoutAds)
-		},
-	))
-	metricAdders = append(metricAdders, createMetricsFunc(addMinutesPlayed))
-	metricAdders = append(metricAdders, createCountPercentageFunc(
-		"non_zero_cirr_ended_plays",
-		func (m *api.Metrics) *api.CountPercentage {
-			return m.NonZeroCirrEndedPlays
-		},
-	))
-	metricAdders = append(metricAdders, createGaugeFunc(
-		"percentage_complete",
-		func (m *api.Metrics) *api.Gauge {
-			return PercentageToGauge(m.PercentageComplete)
-		},
-	))
-	metricAdders = append(metricAdders, createCountPercentageFunc(
-		"plays",
-		func (m *api.Metrics) *api.CountPercentage {
-			return m.Plays
-		},
-	))
-	metricAdders = append(metricAdders, createGaugeFunc(
-		"rebuffering_ratio",
-		func (m *api.Metrics) *api.Gauge {
-			return RatioToGauge(m.RebufferingRatio)
-		},
-	))
-	metricAdders = append(metricAdders, createCountFunc(
-		"spi_streams",
-		func (m *api.Metrics) *api.Count {
-			return m.SpiStreams
-		},
-	))
-	metricAdders = append(metricAdders, createCountFunc(
-		"spi_unique_devices",
-		func (m *api.Metrics) *api.Count {
-			return m.SpiUniqueDevices
-		},
-	))
-	metricAdders = append(metricAdders, createCountFunc(
-		"spi_unique_viewers",
-		func (m *api.Metrics) *api.Count {
-			return m.SpiUniqueViewers
-		},
-	))
-	metricAdders = append(metricAdders, createGaugeFunc(
-		"streaming_performance_index",
-		func (m *api.Metrics) *api.Gauge {
-			return m.StreamingPerformanceIndex
-		},
-	))
-	metricAdders = append(metricAdders, createCountFunc(
-		"unique_devices",
-		func (m *api.Metrics) *api.Count {
-			return m.UniqueDevices
-		},
-	))
-	metricAdders = append(metricAdders, createCountPercentageFunc(
-		"video_playback_failures",
-		func (m *api.Metrics) *api.CountPercentage {
-			return m.VideoPlaybackFailures
-		},
-	))
-	metricAdders = append(metricAdders, createCountPercentageFunc(
-		"video_playback_failures_business",
-		func (m *api.Metrics) *api.CountPercentage {
-			return m.VideoPlaybackFailuresBusiness
-		},
-	))
-	metricAdders = append(metricAdders, createCountPercentageFunc(
-		"video_playback_failures_tech",
-		func (m *api.Metrics) *api.CountPercentage {
-			return m.VideoPlaybackFailuresTech
-		},
-	))
-	metricAdders = append(metricAdders, createGaugeFunc(
-		"video_playback_failures_tech_with_ads",
-		func (m *api.Metrics) *api.Gauge {
-			return PercentageToGauge(m.VideoPlaybackFailuresTechWithAds)
-		},
-	))
-	metricAdders = append(metricAdders, createGaugeFunc(
-		"video_playback_failures_tech_without_ads",
-		func (m *api.Metrics) *api.Gauge {
-			return PercentageToGauge(m.VideoPlaybackFailuresTechWithoutAds)
-		},
-	))
-	metricAdders = append(metricAdders, createGaugeFunc(
-		"video_restart_time",
-		func (m *api.Metrics) *api.Gauge {
-			return m.VideoRestartTime
-		},
-	))
-	metricAdders = append(metricAdders, createCountPercentageFunc(
-		"video_start_failures",
-		func (m *api.Metrics) *api.CountPercentage {
-			return m.VideoStartFailures
-		},
-	))
-	metricAdders = append(metricAdders, createCountPercentageFunc(
-		"video_start_failures_business",
-		func (m *api.Metrics) *api.CountPercentage {
-			return m.VideoStartFailuresBusiness
-		},
-	))
-	metricAdders = append(metricAdders, createCountPercentageFunc(
-		"video_start_failures_tech",
-		func (m *api.Metrics) *api.CountPercentage {
-			return m.VideoStartFailuresTech
-		},
-	))
-	metricAdders = append(metricAdders, createGaugeFunc(
-		"video_start_failures_tech_with_pre_roll",
-		func (m *api.Metrics) *api.Gauge {
-			return PercentageToGauge(m.VideoStartFailuresTechWithPreRoll)
-		},
-	))
-	metricAdders = append(metricAdders, createGaugeFunc(
-		"video_start_failures_tech_without_pre_roll",
-		func (m *api.Metrics) *api.Gauge {
-			return PercentageToGauge(m.VideoStartFailuresTechWithoutPreRoll)
-		},
-	))
-	metricAdders = append(metricAdders, createGaugeFunc(
-		"video_start_time",
-		func (m *api.Metrics) *api.Gauge {
-			return m.VideoStartTime
-		},
-	))
-	metricAdders = append(metricAdders, createCountPercentageFunc(
-		"zero_cirr_ended_plays",
-		func (m *api.Metrics) *api.CountPercentage {
-			return m.ZeroCirrEndedPlays
-		},
-	))
-}
+	aggregation := api.Dimension{
+		Key:   AGGREGATION_ATTRIBUTE,
+		Value: AGGREGATION_TOTAL,
+	}
 
-func addMetrics(
-	entity        *integration.Entity,
-	metrics       *api.Metrics,
-) {
-	ts := time.UnixMilli(metrics.TimeStamp.EpochMs)
-	for i := 0; i < len(metricAdders); i += 1 {
-		err := metricAdders[i](
+	// The grand total includes the dimension values that the client filters
+	// drop, so it is only emitted when Conviva applied all the filters.
+	if len(q.clientFilters) == 0 {
+		n, err := addDimensionalMetrics(
 			entity,
-			ts,
-			nil,
-			metrics,
+			q,
+			timestamp,
+			&api.DimensionalData{
+				Dimensions: []api.Dimension{aggregation},
+				Metrics:    total.Metrics,
+			},
+			false,
 		)
-		fatalIfErr(err)
+		if err != nil {
+			return 0, err
+		}
+		dataPoints += n
 	}
-}
 
-func addDimensionalMetrics(
-	entity        *integration.Entity,
-	timestamp     time.Time,
-	dimensionData *api.DimensionalData,
-) {
-	for i := 0; i < len(metricAdders); i += 1 {
-		err := metricAdders[i](
+	dimensionalData := trimDimensionalData(
+		filterDimensionalData(total.DimensionalData, q.clientFilters, missing),
+		q.metric.Sort,
+		q.metric.Limit,
+	)
+
+	for i := 0; i < len(dimensionalData); i += 1 {
+		dimensionData := dimensionalData[i]
+
+		n, err := addDimensionalMetrics(
 			entity,
+			q,
 			timestamp,
-			&dimensionData.Dimension,
-			&dimensionData.Metrics,
+			&api.DimensionalData{
+				Dimensions: append(dimensionData.Dimensions, aggregation),
+				Metrics:    dimensionData.Metrics,
+			},
+			false,
 		)
-		fatalIfErr(err)
+		if err != nil {
+			return dataPoints, err
+		}
+		dataPoints += n
 	}
+
+	return dataPoints, nil
 }
 
-func getMetricsData(
-	entity *integration.Entity,
+func newCollector(
 	log sdk_log.Logger,
 	cfg *Config,
-) error {
+) (*api.ConvivaCollector, error) {
 	log.Debugf("creating a new conviva collector.")
 
 	c, err := api.NewConvivaCollector(
@@ -749,51 +283,196 @@ func getMetricsData(
 		log,
 	)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	for _, m := range cfg.Metrics {
-		if len(m.Dimensions) == 0 {
-			metricData, err := getMetricData(c, log, &m)
-			if err != nil {
-				return err
-			} else if metricData != nil {
-				for i := 0; i < len(metricData.TimeSeries); i += 1 {
-					addMetrics(entity, &metricData.TimeSeries[i])
-				}
+	retryPolicy, err := api.NewRetryPolicy(
+		cfg.RetryMaxAttempts,
+		cfg.RetryBaseBackoff,
+		cfg.RetryMaxBackoff,
+		cfg.RetryJitter,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	c.SetRetryPolicy(retryPolicy)
+
+	// Rate limiting is off when the rate limit is not set, while a negative
+	// rate limit is rejected by NewRateLimiter.
+	if cfg.RateLimit != 0 {
+		rateLimiter, err := api.NewRateLimiter(cfg.RateLimit, cfg.RateLimitBurst)
+		if err != nil {
+			return nil, err
+		}
+
+		c.SetRateLimiter(rateLimiter)
+	}
+
+	return c, nil
+}
+
+func getMetricsData(
+	entity *integration.Entity,
+	c *api.ConvivaCollector,
+	log sdk_log.Logger,
+	cfg *Config,
+	state *State,
+) error {
+	return collectQueries(entity, c, log, cfg, state, planQueries(cfg, state))
+}
+
+func collectQueries(
+	entity  *integration.Entity,
+	c       *api.ConvivaCollector,
+	log     sdk_log.Logger,
+	cfg     *Config,
+	state   *State,
+	queries []query,
+) error {
+	then := time.Now()
+	results := runQueries(c, log, queries, cfg.Concurrency, cfg.FailFast)
+
+	resetRaisedAlerts()
+
+	failures := []error{}
+	failed := map[string]bool{}
+
+	for i := 0; i < len(queries); i += 1 {
+		q, r := &queries[i], &results[i]
+		t := &queryTelemetry{stats: requestStats(r)}
+		metrics, events := len(entity.Metrics), len(entity.Events)
+
+		err := r.err
+		if err == nil {
+			var latest int64
+
+			latest, t.dataPoints, err = addQueryResult(entity, log, q, r)
+			if err == nil && state != nil && latest > 0 {
+				state.update(q.key, latest)
 			}
-			continue
 		}
 
-		for _, d := range m.Dimensions {
-			metricData, err := getMetricDataByDimension(c, log, &m, d)
-			if err != nil {
+		// The data of a failed query is dropped, as the query is run again
+		// from the same point on the next run.
+		if err != nil {
+			entity.Metrics = entity.Metrics[:metrics]
+			entity.Events = entity.Events[:events]
+			t.dataPoints = 0
+		}
+
+		if err != nil {
+			err = handleQueryError(log, q.metric, q.dimensions.String(), err)
+			if cfg.FailFast {
 				return err
-			} else if metricData != nil {
-				for i := 0; i < len(metricData.TimeSeries); i += 1 {
-					dimensions := metricData.TimeSeries[i]
-					ts := time.UnixMilli(dimensions.TimeStamp.EpochMs)
-
-					for j := 0; j < len(dimensions.DimensionalData); j += 1 {
-						addDimensionalMetrics(
-							entity,
-							ts,
-							&dimensions.DimensionalData[j],
-						)
-					}
-				}
 			}
+
+			t.errorType = errorType(err)
+			failures = append(failures, err)
+			failed[q.key] = true
+		}
+
+		if err := addQueryTelemetry(entity, then, q, t); err != nil {
+			log.Warnf("failed to add telemetry for %s: %v", q.metric.Name(), err)
+		}
+	}
+
+	err := addRunTelemetry(entity, then, len(queries), len(failures))
+	if err != nil {
+		log.Warnf("failed to add run telemetry: %v", err)
+	}
+
+	if len(failures) > 0 && len(failures) == len(queries) {
+		return &totalFailureError{
+			total: len(queries),
+			errs:  failures,
+		}
+	}
+
+	if len(failures) > 0 {
+		return &partialFailureError{
+			failed: len(failures),
+			total:  len(queries),
+			errs:   failures,
+			keys:   failed,
 		}
 	}
 
 	return nil
 }
 
+func logRateLimiterStats(c *api.ConvivaCollector, log sdk_log.Logger) {
+	stats, ok := c.RateLimiterStats()
+	if !ok {
+		return
+	}
+
+	log.Infof(
+		"rate limiter delayed %d of %d requests, total wait %dms, max wait %dms",
+		stats.Delayed,
+		stats.Requests,
+		stats.TotalWait.Milliseconds(),
+		stats.MaxWait.Milliseconds(),
+	)
+}
+
+func handleQueryError(
+	log sdk_log.Logger,
+	m *ConfigMetric,
+	dimension string,
+	err error,
+) error {
+	var (
+		authErr       *api.AuthError
+		rateLimitErr  *api.RateLimitError
+		badRequestErr *api.BadRequestError
+		serverErr     *api.ServerError
+	)
+
+	query := m.Name()
+	if dimension != "" {
+		query += " by " + dimension
+	}
+
+	switch {
+	case errors.As(err, &authErr):
+		log.Errorf(
+			"conviva rejected the client credentials (status %d), check the CLIENT_ID and CLIENT_SECRET settings",
+			authErr.StatusCode,
+		)
+	case errors.As(err, &rateLimitErr):
+		log.Errorf(
+			"conviva rate limit exceeded while querying %s",
+			query,
+		)
+	case errors.As(err, &badRequestErr):
+		log.Errorf(
+			"conviva rejected the query for %s (status %d): %s",
+			query,
+			badRequestErr.StatusCode,
+			badRequestErr.Message,
+		)
+	case errors.As(err, &serverErr):
+		log.Errorf(
+			"conviva returned a server error while querying %s (status %d): %s",
+			query,
+			serverErr.StatusCode,
+			serverErr.Message,
+		)
+	default:
+		log.Errorf("query for %s failed: %v", query, err)
+	}
+
+	return err
+}
+
 func getMetricData(
 	c *api.ConvivaCollector,
 	log sdk_log.Logger,
 	m *ConfigMetric,
 ) (*api.MetricData, error) {
+	filters, _ := splitFilters(m.Filters)
+
 	if m.MetricGroup != "" {
 		log.Debugf(
 			"collecting conviva metrics for metric group %s...",
@@ -802,11 +481,12 @@ func getMetricData(
 
 		return c.CollectMetricGroup(
 			m.MetricGroup,
-			m.Filters,
+			filters,
 			m.StartOffset,
 			m.EndOffset,
 			m.Granularity,
 			m.RealTime,
+			m.queryOptions(false),
 		)
 	} else if m.Metric != "" {
 		log.Debugf(
@@ -816,11 +496,12 @@ func getMetricData(
 
 		return c.CollectMetrics(
 			[]string {m.Metric},
-			m.Filters,
+			filters,
 			m.StartOffset,
 			m.EndOffset,
 			m.Granularity,
 			m.RealTime,
+			m.queryOptions(false),
 		)
 	} else if len(m.Names) > 0 {
 		log.Debugf(
@@ -830,11 +511,12 @@ func getMetricData(
 
 		return c.CollectMetrics(
 			m.Names,
-			m.Filters,
+			filters,
 			m.StartOffset,
 			m.EndOffset,
 			m.Granularity,
 			m.RealTime,
+			m.queryOptions(false),
 		)
 	}
 
@@ -845,11 +527,13 @@ func getMetricDataByDimension(
 	c *api.ConvivaCollector,
 	log sdk_log.Logger,
 	m *ConfigMetric,
-	d string,
+	d DimensionSet,
 ) (*api.DimMetricData, error) {
+	filters, _ := splitFilters(m.Filters)
+
 	if m.MetricGroup != "" {
 		log.Debugf(
-			"collecting conviva metrics for metric group %s and dimension %s...",
+			"collecting conviva metrics for metric group %s and dimensions %s...",
 			m.MetricGroup,
 			d,
 		)
@@ -857,15 +541,16 @@ func getMetricDataByDimension(
 		return c.CollectMetricGroupByDimension(
 			m.MetricGroup,
 			d,
-			m.Filters,
+			filters,
 			m.StartOffset,
 			m.EndOffset,
 			m.Granularity,
 			m.RealTime,
+			m.queryOptions(true),
 		)
 	} else if m.Metric != "" {
 		log.Debugf(
-			"collecting conviva metrics for metric %s and dimension %s...",
+			"collecting conviva metrics for metric %s and dimensions %s...",
 			m.Metric,
 			d,
 		)
@@ -873,15 +558,16 @@ func getMetricDataByDimension(
 		return c.CollectMetricsByDimension(
 			[]string {m.Metric},
 			d,
-			m.Filters,
+			filters,
 			m.StartOffset,
 			m.EndOffset,
 			m.Granularity,
 			m.RealTime,
+			m.queryOptions(true),
 		)
 	} else if len(m.Names) > 0 {
 		log.Debugf(
-			"collecting conviva metrics for metrics %v and dimension %s...",
+			"collecting conviva metrics for metrics %v and dimensions %s...",
 			m.Names,
 			d,
 		)
@@ -889,11 +575,12 @@ func getMetricDataByDimension(
 		return c.CollectMetricsByDimension(
 			m.Names,
 			d,
-			m.Filters,
+			filters,
 			m.StartOffset,
 			m.EndOffset,
 			m.Granularity,
 			m.RealTime,
+			m.queryOptions(true),
 		)
 	}
 
@@ -0,0 +1,356 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/newrelic/infra-integrations-sdk/v4/integration"
+	sdk_log "github.com/newrelic/infra-integrations-sdk/v4/log"
+	"github.com/newrelic/nri-conviva/src/api"
+)
+
+var testLog = sdk_log.NewStdErr(false)
+
+// testEntity returns a host entity to add the results of a test query to.
+func testEntity(t *testing.T) *integration.Entity {
+	t.Helper()
+
+	i, err := integration.New("test", "0.0.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return i.HostEntity
+}
+
+// initTestMetrics initializes the package state for a configuration and
+// restores it when the test ends.
+func initTestMetrics(t *testing.T, cfg *Config) {
+	t.Helper()
+
+	registry, rules, histories := metricRegistry, alertRules, anomalyHistories
+	t.Cleanup(func() {
+		metricRegistry, alertRules, anomalyHistories = registry, rules, histories
+	})
+
+	if err := initMetrics(cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	anomalyHistories = map[string]*AnomalyHistory{}
+	resetRaisedAlerts()
+}
+
+func testMetrics(t *testing.T, epochMs int64, body string) api.Metrics {
+	t.Helper()
+
+	m := api.Metrics{}
+	if err := json.Unmarshal([]byte(body), &m.Raw); err != nil {
+		t.Fatal(err)
+	}
+	m.TimeStamp.EpochMs = epochMs
+
+	return m
+}
+
+func countEvents(entity *integration.Entity, category string) int {
+	n := 0
+	for _, e := range entity.Events {
+		if e.Category == category {
+			n += 1
+		}
+	}
+	return n
+}
+
+func TestAlertsSkipTotals(t *testing.T) {
+	cfg := &Config{
+		Alerts:  []AlertRule{{Rule: "rebuffering_ratio > 0.02"}},
+		Metrics: []ConfigMetric{{Metric: "rebuffering_ratio"}},
+	}
+	initTestMetrics(t, cfg)
+
+	entity := testEntity(t)
+	now := time.Now()
+	q := &query{metric: &cfg.Metrics[0], emitTotals: true}
+
+	r := &queryResult{
+		metricData: &api.MetricData{
+			TimeSeries: []api.Metrics{
+				testMetrics(t, now.Add(-time.Minute).UnixMilli(), `{"rebuffering_ratio": {"ratio": 0.05}}`),
+			},
+			Total: api.Total{
+				Metrics: testMetrics(t, 0, `{"rebuffering_ratio": {"ratio": 0.05}}`),
+			},
+			WindowEnd: now,
+		},
+	}
+
+	if _, _, err := addQueryResult(entity, testLog, q, r); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := countEvents(entity, ALERT_EVENT_CATEGORY); got != 1 {
+		t.Errorf("got %d alert events, want 1 for the time series point", got)
+	}
+}
+
+func TestTotalsWithClientFilters(t *testing.T) {
+	cfg := &Config{
+		Metrics: []ConfigMetric{{
+			Metric:     "plays",
+			Dimensions: []DimensionSet{{"device-name"}},
+			Filters:    map[string][]string{"device-name": {"!QA"}},
+		}},
+	}
+	initTestMetrics(t, cfg)
+
+	_, clientFilters := splitFilters(cfg.Metrics[0].Filters)
+
+	row := func(device string) api.DimensionalData {
+		return api.DimensionalData{
+			Dimensions: []api.Dimension{{Key: "device-name", Value: device}},
+			Metrics:    testMetrics(t, 0, `{"plays": {"count": 5, "percentage": 50}}`),
+		}
+	}
+
+	r := &queryResult{
+		dimMetricData: &api.DimMetricData{
+			Total: api.Total{
+				Metrics:         testMetrics(t, 0, `{"plays": {"count": 10, "percentage": 100}}`),
+				DimensionalData: []api.DimensionalData{row("Roku"), row("QA")},
+			},
+			WindowEnd: time.Now(),
+		},
+	}
+
+	tests := []struct {
+		clientFilters []dimensionFilter
+		want          int
+	}{
+		// The count and percentage of the grand total and of each device.
+		{nil, 6},
+		// The count and percentage of Roku only.
+		{clientFilters, 2},
+	}
+
+	for _, tt := range tests {
+		entity := testEntity(t)
+		q := &query{
+			metric:        &cfg.Metrics[0],
+			emitTotals:    true,
+			clientFilters: tt.clientFilters,
+		}
+
+		if _, _, err := addQueryResult(entity, testLog, q, r); err != nil {
+			t.Fatal(err)
+		}
+
+		if got := len(entity.Metrics); got != tt.want {
+			t.Errorf(
+				"got %d total metrics with filters %v, want %d",
+				got,
+				tt.clientFilters,
+				tt.want,
+			)
+		}
+	}
+}
+
+// testCollector returns a collector for the configuration that sends its
+// requests to the handler.
+func testCollector(
+	t       *testing.T,
+	cfg     *Config,
+	handler http.HandlerFunc,
+) *api.ConvivaCollector {
+	t.Helper()
+
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	cfg.ApiV3URL = server.URL
+
+	c, err := newCollector(testLog, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return c
+}
+
+func TestCollectQueriesFailures(t *testing.T) {
+	cfg := &Config{
+		RetryMaxAttempts: 1,
+		Metrics: []ConfigMetric{
+			{Metric: "plays"},
+			{Metric: "bitrate"},
+		},
+	}
+	initTestMetrics(t, cfg)
+
+	body := fmt.Sprintf(
+		`{"time_series": [{"timestamp": {"epoch_ms": %d}, "plays": {"count": 5, "percentage": 50}}], "total": {}}`,
+		time.Now().Add(-time.Minute).UnixMilli(),
+	)
+
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		check   func(error) bool
+	}{
+		{
+			"unauthorized",
+			func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusUnauthorized)
+			},
+			func(err error) bool {
+				return isTotalFailure(err) && isFatalQueryError(err)
+			},
+		},
+		{
+			"bad requests",
+			func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusBadRequest)
+			},
+			func(err error) bool {
+				return isTotalFailure(err) && !isFatalQueryError(err)
+			},
+		},
+		{
+			"one bad request",
+			func(w http.ResponseWriter, r *http.Request) {
+				if strings.Contains(r.URL.Path, "bitrate") {
+					w.WriteHeader(http.StatusBadRequest)
+					return
+				}
+				w.Write([]byte(body))
+			},
+			isPartialFailure,
+		},
+	}
+
+	for _, tt := range tests {
+		c := testCollector(t, cfg, tt.handler)
+
+		queries := planQueries(cfg, nil)
+		err := collectQueries(testEntity(t), c, testLog, cfg, nil, queries)
+		if !tt.check(err) {
+			t.Errorf("%s: unexpected error %v", tt.name, err)
+		}
+	}
+}
+
+func TestCollectQueriesDropsDataOfFailedQuery(t *testing.T) {
+	cfg := &Config{
+		RetryMaxAttempts: 1,
+		Metrics: []ConfigMetric{{
+			Metric:     "plays",
+			Dimensions: []DimensionSet{{"cdn"}},
+		}},
+	}
+	initTestMetrics(t, cfg)
+
+	// The second dimension value has no key, which fails the query after
+	// the first dimension value was added.
+	body := fmt.Sprintf(
+		`{"time_series": [{"timestamp": {"epoch_ms": %d}, "dimensional_data": [
+			{"dimension": {"key": "cdn", "value": "Akamai"}, "metrics": {"plays": {"count": 5, "percentage": 50}}},
+			{"dimension": {"key": "", "value": "Fastly"}, "metrics": {"plays": {"count": 5, "percentage": 50}}}
+		]}], "total": {}}`,
+		time.Now().Add(-time.Minute).UnixMilli(),
+	)
+
+	c := testCollector(t, cfg, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(body))
+	})
+
+	entity := testEntity(t)
+
+	err := collectQueries(entity, c, testLog, cfg, nil, planQueries(cfg, nil))
+	if !isTotalFailure(err) {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	metrics, err := json.Marshal(entity.Metrics)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Contains(string(metrics), `"name":"conviva.plays"`) {
+		t.Errorf("the data of the failed query was kept: %s", metrics)
+	}
+}
+
+func TestAddQueryResultCountsDataPoints(t *testing.T) {
+	cfg := &Config{
+		Alerts:  []AlertRule{{Rule: "rebuffering_ratio > 0.02"}},
+		Metrics: []ConfigMetric{{Metric: "rebuffering_ratio"}},
+	}
+	initTestMetrics(t, cfg)
+
+	entity := testEntity(t)
+	now := time.Now()
+	q := &query{metric: &cfg.Metrics[0], emitTotals: true}
+
+	r := &queryResult{
+		metricData: &api.MetricData{
+			TimeSeries: []api.Metrics{
+				testMetrics(t, now.Add(-time.Minute).UnixMilli(), `{"rebuffering_ratio": {"ratio": 0.05}}`),
+			},
+			Total: api.Total{
+				Metrics: testMetrics(t, 0, `{"rebuffering_ratio": {"ratio": 0.05}}`),
+			},
+			WindowEnd: now,
+		},
+	}
+
+	_, dataPoints, err := addQueryResult(entity, testLog, q, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The time series point and the total, but not the alert event.
+	if dataPoints != 2 {
+		t.Errorf("got %d data points, want 2", dataPoints)
+	}
+
+	if got := len(entity.Metrics) + len(entity.Events); got != 3 {
+		t.Errorf("got %d metrics and events, want 3", got)
+	}
+}
+
+func TestNewCollectorRateLimit(t *testing.T) {
+	tests := []struct {
+		rateLimit float64
+		limited   bool
+		wantErr   bool
+	}{
+		{0, false, false},
+		{2.5, true, false},
+		{-1, false, true},
+	}
+
+	for _, tt := range tests {
+		cfg := &Config{ApiV3URL: DEFAULT_API_V3_URL, RateLimit: tt.rateLimit}
+
+		c, err := newCollector(testLog, cfg)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("rate limit %v: unexpected error %v", tt.rateLimit, err)
+			continue
+		}
+
+		if err != nil {
+			continue
+		}
+
+		if _, ok := c.RateLimiterStats(); ok != tt.limited {
+			t.Errorf("rate limit %v: got rate limiting %v, want %v", tt.rateLimit, ok, tt.limited)
+		}
+	}
+}
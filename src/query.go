@@ -0,0 +1,257 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	sdk_log "github.com/newrelic/infra-integrations-sdk/v4/log"
+	"github.com/newrelic/nri-conviva/src/api"
+)
+
+const (
+	DEFAULT_CONCURRENCY = 1
+)
+
+var (
+	errQuerySkipped = errors.New("query skipped after a previous failure")
+)
+
+type query struct {
+	index      int
+	metric     *ConfigMetric
+	dimensions DimensionSet
+	key        string
+	since      int64
+	emitTotals bool
+	requested  map[string]bool
+	attributes []api.Dimension
+	events     bool
+	// clientFilters are the filters applied to the dimensional data
+	// because Conviva cannot apply them.
+	clientFilters []dimensionFilter
+}
+
+type queryResult struct {
+	metricData    *api.MetricData
+	dimMetricData *api.DimMetricData
+	err           error
+}
+
+// planQueries expands the configured metric definitions into the list of API
+// queries to run, one per metric definition without dimensions and one per
+// dimension set otherwise. The order matches the configuration so that results
+// are always emitted in the same order. When state is provided, each query
+// starts from the last data point emitted for it on a previous run.
+func planQueries(cfg *Config, state *State) []query {
+	queries := []query{}
+
+	for i := 0; i < len(cfg.Metrics); i += 1 {
+		m := &cfg.Metrics[i]
+
+		if len(m.Dimensions) == 0 {
+			queries = append(queries, newQuery(cfg, state, i, m, nil))
+			continue
+		}
+
+		for _, d := range m.Dimensions {
+			queries = append(queries, newQuery(cfg, state, i, m, d))
+		}
+	}
+
+	return queries
+}
+
+func newQuery(
+	cfg        *Config,
+	state      *State,
+	index      int,
+	m          *ConfigMetric,
+	dimensions DimensionSet,
+) query {
+	q := query{
+		index:      index,
+		metric:     m,
+		dimensions: dimensions,
+		requested:  m.requestedMetrics(),
+		attributes: staticAttributes(m),
+		events:     m.Output == OUTPUT_EVENTS,
+	}
+
+	_, q.clientFilters = splitFilters(m.Filters)
+
+	q.emitTotals = cfg.EmitTotals
+	if m.EmitTotals != nil {
+		q.emitTotals = *m.EmitTotals
+	}
+
+	granularity := m.Granularity
+	if granularity == "" {
+		granularity = cfg.Granularity
+	}
+
+	q.key = stateKey(m, dimensions.String(), granularity)
+
+	if state == nil {
+		return q
+	}
+
+	mark, ok := state.mark(q.key)
+	if !ok {
+		return q
+	}
+
+	q.since = mark
+
+	endOffset := m.EndOffset
+	if endOffset == "" {
+		endOffset = cfg.EndOffset
+	}
+
+	end := time.Duration(0)
+	if endOffset != "" {
+		d, err := time.ParseDuration(endOffset)
+		if err != nil {
+			return q
+		}
+		end = d
+	}
+
+	// If there is nothing new since the mark the configured window is used
+	// as is and already emitted points are skipped.
+	lookback := state.lookback(mark)
+	if lookback > end {
+		mc := *m
+		mc.StartOffset = lookback.String()
+		q.metric = &mc
+	}
+
+	return q
+}
+
+// partialFailureError is returned when some, but not necessarily all, queries
+// failed. Data for the queries that succeeded has already been added to the
+// entity and can still be published. Keys holds the keys of the queries that
+// failed.
+type partialFailureError struct {
+	failed int
+	total  int
+	errs   []error
+	keys   map[string]bool
+}
+
+func (e *partialFailureError) Error() string {
+	return fmt.Sprintf("%d of %d queries failed", e.failed, e.total)
+}
+
+func (e *partialFailureError) Unwrap() []error {
+	return e.errs
+}
+
+// totalFailureError is returned when every query failed, so no data was
+// collected. It carries the error of the first query, which for an
+// authentication failure is the reason the others were skipped.
+type totalFailureError struct {
+	total int
+	errs  []error
+}
+
+func (e *totalFailureError) Error() string {
+	return fmt.Sprintf("all %d queries failed: %v", e.total, e.errs[0])
+}
+
+func (e *totalFailureError) Unwrap() []error {
+	return e.errs
+}
+
+// isFatalQueryError reports whether a query error means that no other query
+// can succeed either, in which case the remaining queries are not run.
+func isFatalQueryError(err error) bool {
+	var authErr *api.AuthError
+
+	return errors.As(err, &authErr)
+}
+
+func (q *query) run(
+	c *api.ConvivaCollector,
+	log sdk_log.Logger,
+) queryResult {
+	if len(q.dimensions) == 0 {
+		metricData, err := getMetricData(c, log, q.metric)
+		return queryResult{metricData: metricData, err: err}
+	}
+
+	dimMetricData, err := getMetricDataByDimension(
+		c,
+		log,
+		q.metric,
+		q.dimensions,
+	)
+	return queryResult{dimMetricData: dimMetricData, err: err}
+}
+
+// runQueries runs the given queries on a pool of at most concurrency workers
+// and returns the results in the same order as the queries. When failFast is
+// set, or a query fails in a way no other query can recover from, no new
+// queries are started and the queries that were not run are marked skipped.
+func runQueries(
+	c *api.ConvivaCollector,
+	log sdk_log.Logger,
+	queries []query,
+	concurrency int,
+	failFast bool,
+) []queryResult {
+	var (
+		wg     sync.WaitGroup
+		failed atomic.Bool
+		next   int
+	)
+
+	results := make([]queryResult, len(queries))
+	jobs := make(chan int)
+
+	if concurrency < 1 {
+		concurrency = DEFAULT_CONCURRENCY
+	}
+
+	if concurrency > len(queries) {
+		concurrency = len(queries)
+	}
+
+	log.Debugf(
+		"running %d queries with %d workers...",
+		len(queries),
+		concurrency,
+	)
+
+	for w := 0; w < concurrency; w += 1 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for i := range jobs {
+				results[i] = queries[i].run(c, log)
+
+				err := results[i].err
+				if err != nil && (failFast || isFatalQueryError(err)) {
+					failed.Store(true)
+				}
+			}
+		}()
+	}
+
+	for next = 0; next < len(queries) && !failed.Load(); next += 1 {
+		jobs <- next
+	}
+
+	for ; next < len(queries); next += 1 {
+		results[next] = queryResult{err: errQuerySkipped}
+	}
+
+	close(jobs)
+	wg.Wait()
+
+	return results
+}
@@ -0,0 +1,537 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/newrelic/infra-integrations-sdk/v4/integration"
+	"github.com/newrelic/nri-conviva/src/api"
+)
+
+type MetricType string
+
+const (
+	METRIC_TYPE_COUNT            MetricType = "count"
+	METRIC_TYPE_GAUGE            MetricType = "gauge"
+	METRIC_TYPE_PERCENTAGE       MetricType = "percentage"
+	METRIC_TYPE_COUNT_PERCENTAGE MetricType = "countPercentage"
+	METRIC_TYPE_RATIO            MetricType = "ratio"
+	METRIC_TYPE_BITRATE          MetricType = "bitrate"
+	METRIC_TYPE_FRAMERATE        MetricType = "framerate"
+	METRIC_TYPE_ENDED_PLAYS      MetricType = "endedPlays"
+	METRIC_TYPE_MINUTES_PLAYED   MetricType = "minutesPlayed"
+)
+
+// metricValue maps one value of a Conviva metric to a New Relic metric.
+type metricValue struct {
+	key    string
+	suffix string
+	count  bool
+	unit   Unit
+}
+
+// metricTypeValues describes, for each metric type, which values of the
+// Conviva metric are emitted and how they are named.
+var metricTypeValues = map[MetricType][]metricValue{
+	METRIC_TYPE_COUNT: {
+		{"count", "", true, ""},
+	},
+	METRIC_TYPE_GAUGE: {
+		{"value", "", false, ""},
+	},
+	METRIC_TYPE_PERCENTAGE: {
+		{"percentage", "", false, UNIT_PERCENT},
+	},
+	METRIC_TYPE_COUNT_PERCENTAGE: {
+		{"count", "", true, ""},
+		{"percentage", PERCENTAGE_SUFFIX, false, UNIT_PERCENT},
+	},
+	METRIC_TYPE_RATIO: {
+		{"ratio", "", false, UNIT_FRACTION},
+	},
+	METRIC_TYPE_BITRATE: {
+		{"bps", "", false, UNIT_BPS},
+	},
+	METRIC_TYPE_FRAMERATE: {
+		{"fps", "", false, UNIT_FPS},
+	},
+	METRIC_TYPE_ENDED_PLAYS: {
+		{"count", "", true, ""},
+		{"per_unique_device", ".per_unique_device", false, ""},
+	},
+	METRIC_TYPE_MINUTES_PLAYED: {
+		{"count", "", true, ""},
+		{"per_ended_play", ".per_ended_play", false, UNIT_MINUTES},
+	},
+}
+
+// MetricDefinition describes how a metric in the Conviva response is emitted.
+// Field is the name of the metric in the response, Name is the New Relic
+// metric name without the prefix (defaulting to Field) and Scale multiplies
+// gauge values (defaulting to 1). Unit is the unit of the value of a gauge,
+// which the other types determine themselves.
+type MetricDefinition struct {
+	Field string     `yaml:"field"`
+	Name  string     `yaml:"name"`
+	Type  MetricType `yaml:"type"`
+	Scale float64    `yaml:"scale"`
+	Unit  Unit       `yaml:"unit"`
+}
+
+var defaultMetricDefinitions = []MetricDefinition{
+	{Field: "abandonment", Type: METRIC_TYPE_COUNT_PERCENTAGE},
+	{Field: "abandonment_with_pre_roll", Type: METRIC_TYPE_PERCENTAGE},
+	{Field: "abandonment_without_pre_roll", Type: METRIC_TYPE_PERCENTAGE},
+	{Field: "ad_actual_duration", Type: METRIC_TYPE_GAUGE, Unit: UNIT_SECONDS},
+	{Field: "ad_attempts", Type: METRIC_TYPE_COUNT},
+	{Field: "ad_bitrate", Type: METRIC_TYPE_BITRATE},
+	{Field: "ad_completed_creative_plays", Type: METRIC_TYPE_GAUGE},
+	{Field: "ad_concurrent_plays", Type: METRIC_TYPE_COUNT},
+	{Field: "ad_connection_induced_rebuffering_ratio", Type: METRIC_TYPE_RATIO},
+	{Field: "ad_ended_plays", Type: METRIC_TYPE_ENDED_PLAYS},
+	{Field: "ad_framerate", Type: METRIC_TYPE_FRAMERATE},
+	{Field: "ad_minutes_played", Type: METRIC_TYPE_MINUTES_PLAYED},
+	{Field: "ad_percentage_complete", Type: METRIC_TYPE_PERCENTAGE},
+	{Field: "ad_plays", Type: METRIC_TYPE_COUNT_PERCENTAGE},
+	{Field: "ad_rebuffering_ratio", Type: METRIC_TYPE_RATIO},
+	{Field: "ad_unique_devices", Type: METRIC_TYPE_COUNT},
+	{Field: "ad_video_playback_failures", Type: METRIC_TYPE_COUNT_PERCENTAGE},
+	{Field: "ad_video_restart_time", Type: METRIC_TYPE_GAUGE, Unit: UNIT_MILLISECONDS},
+	{Field: "ad_video_start_failures", Type: METRIC_TYPE_COUNT_PERCENTAGE},
+	{Field: "ad_video_start_time", Type: METRIC_TYPE_GAUGE, Unit: UNIT_MILLISECONDS},
+	{Field: "attempts", Type: METRIC_TYPE_COUNT},
+	{Field: "attempts_with_pre_roll", Type: METRIC_TYPE_COUNT_PERCENTAGE},
+	{Field: "attempts_without_pre_roll", Type: METRIC_TYPE_COUNT_PERCENTAGE},
+	{Field: "bad_session", Type: METRIC_TYPE_COUNT_PERCENTAGE},
+	{Field: "bad_session_average_life_playing_time_mins", Type: METRIC_TYPE_GAUGE, Unit: UNIT_MINUTES},
+	{Field: "bad_unique_devices", Type: METRIC_TYPE_COUNT_PERCENTAGE},
+	{Field: "bad_unique_viewers", Type: METRIC_TYPE_COUNT_PERCENTAGE},
+	{Field: "bitrate", Type: METRIC_TYPE_BITRATE},
+	{Field: "concurrent_plays", Type: METRIC_TYPE_COUNT},
+	{Field: "connection_induced_rebuffering_ratio", Type: METRIC_TYPE_RATIO},
+	{Field: "ended_plays", Type: METRIC_TYPE_ENDED_PLAYS},
+	{Field: "ended_plays_with_ads", Type: METRIC_TYPE_COUNT_PERCENTAGE},
+	{Field: "ended_plays_without_ads", Type: METRIC_TYPE_COUNT_PERCENTAGE},
+	{Field: "exit_before_video_starts", Type: METRIC_TYPE_COUNT_PERCENTAGE},
+	{Field: "exits_before_ad_start", Name: "ad_exit_before_video_starts", Type: METRIC_TYPE_COUNT_PERCENTAGE},
+	{Field: "framerate", Type: METRIC_TYPE_FRAMERATE},
+	{Field: "good_session", Type: METRIC_TYPE_COUNT},
+	{Field: "good_session_average_life_playing_time_mins", Type: METRIC_TYPE_GAUGE, Unit: UNIT_MINUTES},
+	{Field: "good_unique_devices", Type: METRIC_TYPE_COUNT},
+	{Field: "good_unique_viewers", Type: METRIC_TYPE_COUNT},
+	{Field: "high_rebuffering", Type: METRIC_TYPE_COUNT_PERCENTAGE},
+	{Field: "high_rebuffering_with_ads", Type: METRIC_TYPE_PERCENTAGE},
+	{Field: "high_rebuffering_without_ads", Type: METRIC_TYPE_PERCENTAGE},
+	{Field: "high_startup_time", Type: METRIC_TYPE_COUNT_PERCENTAGE},
+	{Field: "high_startup_time_with_pre_roll", Type: METRIC_TYPE_PERCENTAGE},
+	{Field: "high_startup_time_without_pre_roll", Type: METRIC_TYPE_PERCENTAGE},
+	{Field: "interval_minutes_played", Type: METRIC_TYPE_COUNT},
+	{Field: "low_bitrate", Type: METRIC_TYPE_COUNT_PERCENTAGE},
+	{Field: "low_bitrate_with_ads", Type: METRIC_TYPE_PERCENTAGE},
+	{Field: "low_bitrate_without_ads", Type: METRIC_TYPE_PERCENTAGE},
+	{Field: "minutes_played", Type: METRIC_TYPE_MINUTES_PLAYED},
+	{Field: "non_zero_cirr_ended_plays", Type: METRIC_TYPE_COUNT_PERCENTAGE},
+	{Field: "percentage_complete", Type: METRIC_TYPE_PERCENTAGE},
+	{Field: "plays", Type: METRIC_TYPE_COUNT_PERCENTAGE},
+	{Field: "rebuffering_ratio", Type: METRIC_TYPE_RATIO},
+	{Field: "spi_streams", Type: METRIC_TYPE_COUNT},
+	{Field: "spi_unique_devices", Type: METRIC_TYPE_COUNT},
+	{Field: "spi_unique_viewers", Type: METRIC_TYPE_COUNT},
+	{Field: "streaming_performance_index", Type: METRIC_TYPE_GAUGE},
+	{Field: "unique_devices", Type: METRIC_TYPE_COUNT},
+	{Field: "video_playback_failures", Type: METRIC_TYPE_COUNT_PERCENTAGE},
+	{Field: "video_playback_failures_business", Type: METRIC_TYPE_COUNT_PERCENTAGE},
+	{Field: "video_playback_failures_tech", Type: METRIC_TYPE_COUNT_PERCENTAGE},
+	{Field: "video_playback_failures_tech_with_ads", Type: METRIC_TYPE_PERCENTAGE},
+	{Field: "video_playback_failures_tech_without_ads", Type: METRIC_TYPE_PERCENTAGE},
+	{Field: "video_restart_time", Type: METRIC_TYPE_GAUGE, Unit: UNIT_MILLISECONDS},
+	{Field: "video_start_failures", Type: METRIC_TYPE_COUNT_PERCENTAGE},
+	{Field: "video_start_failures_business", Type: METRIC_TYPE_COUNT_PERCENTAGE},
+	{Field: "video_start_failures_tech", Type: METRIC_TYPE_COUNT_PERCENTAGE},
+	{Field: "video_start_failures_tech_with_pre_roll", Type: METRIC_TYPE_PERCENTAGE},
+	{Field: "video_start_failures_tech_without_pre_roll", Type: METRIC_TYPE_PERCENTAGE},
+	{Field: "video_start_time", Type: METRIC_TYPE_GAUGE, Unit: UNIT_MILLISECONDS},
+	{Field: "zero_cirr_ended_plays", Type: METRIC_TYPE_COUNT_PERCENTAGE},
+}
+
+type MetricRegistry struct {
+	definitions map[string]*MetricDefinition
+}
+
+var (
+	metricRegistry *MetricRegistry
+)
+
+// fieldName converts a Conviva metric name as used in API paths, such as
+// ad-bitrate, to the name of the metric in the response, such as ad_bitrate.
+func fieldName(metricName string) string {
+	return strings.ReplaceAll(metricName, "-", "_")
+}
+
+// newMetricRegistry creates a registry of the default metric definitions
+// extended, or overridden by field, with the given definitions.
+func newMetricRegistry(extra []MetricDefinition) (*MetricRegistry, error) {
+	r := &MetricRegistry{definitions: map[string]*MetricDefinition{}}
+
+	definitions := append(
+		append([]MetricDefinition{}, defaultMetricDefinitions...),
+		extra...,
+	)
+
+	for i := 0; i < len(definitions); i += 1 {
+		err := r.Register(definitions[i])
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return r, nil
+}
+
+func (r *MetricRegistry) Register(d MetricDefinition) error {
+	if d.Field == "" {
+		return fmt.Errorf("metric definition has no field")
+	}
+
+	if _, ok := metricTypeValues[d.Type]; !ok {
+		return fmt.Errorf(
+			"metric definition for %s has unknown type %q",
+			d.Field,
+			d.Type,
+		)
+	}
+
+	if d.Unit != "" && !validUnit(d.Unit) {
+		return fmt.Errorf(
+			"metric definition for %s has unknown unit %q",
+			d.Field,
+			d.Unit,
+		)
+	}
+
+	d.Field = fieldName(d.Field)
+
+	if d.Name == "" {
+		d.Name = d.Field
+	}
+
+	if d.Scale == 0 {
+		d.Scale = 1
+	}
+
+	r.definitions[d.Field] = &d
+
+	return nil
+}
+
+// Lookup returns the definition for a Conviva metric name, given either as
+// used in API paths or as it appears in the response.
+func (r *MetricRegistry) Lookup(metricName string) (*MetricDefinition, bool) {
+	d, ok := r.definitions[fieldName(metricName)]
+	return d, ok
+}
+
+// valueUnit returns the unit Conviva reports a value of the metric in.
+func (d *MetricDefinition) valueUnit(v metricValue) Unit {
+	if v.unit != "" {
+		return v.unit
+	}
+	return d.Unit
+}
+
+// Definitions returns all definitions sorted by field.
+func (r *MetricRegistry) Definitions() []*MetricDefinition {
+	definitions := make([]*MetricDefinition, 0, len(r.definitions))
+
+	for _, d := range r.definitions {
+		definitions = append(definitions, d)
+	}
+
+	sort.Slice(definitions, func(i, j int) bool {
+		return definitions[i].Field < definitions[j].Field
+	})
+
+	return definitions
+}
+
+func initMetrics(cfg *Config) error {
+	r, err := newMetricRegistry(cfg.MetricDefinitions)
+	if err != nil {
+		return err
+	}
+
+	err = initUnits(cfg, r)
+	if err != nil {
+		return err
+	}
+
+	err = initDerivedMetrics(cfg)
+	if err != nil {
+		return err
+	}
+
+	err = checkOutputs(cfg)
+	if err != nil {
+		return err
+	}
+
+	err = initAlerts(cfg)
+	if err != nil {
+		return err
+	}
+
+	err = checkAnomalyConfig(cfg)
+	if err != nil {
+		return err
+	}
+
+	err = checkSortOptions(cfg)
+	if err != nil {
+		return err
+	}
+
+	metricRegistry = r
+	initMappings(cfg)
+
+	return nil
+}
+
+func printMetricDefinitions(r *MetricRegistry) {
+	fmt.Printf("%-45s %-16s %s\n", "FIELD", "TYPE", "METRICS")
+
+	for _, d := range r.Definitions() {
+		names := []string{}
+		for _, v := range metricTypeValues[d.Type] {
+			names = append(names, metricMappings.metricName(d.Name + v.suffix))
+		}
+
+		fmt.Printf(
+			"%-45s %-16s %s\n",
+			d.Field,
+			d.Type,
+			strings.Join(names, ", "),
+		)
+	}
+}
+
+// decodeMetricFields returns the numeric fields of a metric in the response,
+// or false if the metric is null or not an object.
+func decodeMetricFields(raw json.RawMessage) (map[string]float64, bool) {
+	var (
+		number float64
+		fields map[string]json.RawMessage
+	)
+
+	values := map[string]float64{}
+
+	if err := json.Unmarshal(raw, &fields); err != nil || fields == nil {
+		return values, false
+	}
+
+	for k, v := range fields {
+		if err := json.Unmarshal(v, &number); err == nil {
+			values[k] = number
+		}
+	}
+
+	return values, true
+}
+
+// pointValue is a single value of a data point, named as it is emitted
+// without the prefix.
+type pointValue struct {
+	name  string
+	value float64
+	count bool
+	unit  Unit
+}
+
+// definedValues returns the values of a registered Conviva metric, converted
+// to the units they are emitted in.
+func definedValues(
+	d   *MetricDefinition,
+	raw json.RawMessage,
+) ([]pointValue, error) {
+	values, ok := decodeMetricFields(raw)
+	if !ok {
+		return nil, nil
+	}
+
+	points := []pointValue{}
+
+	// A value missing from a metric that is present is reported as zero.
+	for _, v := range metricTypeValues[d.Type] {
+		value := values[v.key]
+
+		if v.count {
+			points = append(points, pointValue{
+				name:  d.Name + v.suffix,
+				value: float64(int64(value)),
+				count: true,
+			})
+			continue
+		}
+
+		value, unit, err := unitsConfig.normalizeUnit(
+			d.Field,
+			value,
+			d.valueUnit(v),
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		points = append(points, pointValue{
+			name:  d.Name + v.suffix,
+			value: value * d.Scale,
+			unit:  unit,
+		})
+	}
+
+	return points, nil
+}
+
+// untypedValues returns the values of a Conviva metric that is not in the
+// registry, such as a metric added by Conviva after this integration was
+// released or a custom metric, based on the shape of its values.
+func untypedValues(name string, raw json.RawMessage) []pointValue {
+	values, err := api.DecodeMetricValues(raw)
+	if err != nil {
+		// Values that are neither numbers nor objects are not metrics.
+		return nil
+	}
+
+	points := make([]pointValue, 0, len(values))
+
+	for _, v := range values {
+		points = append(points, pointValue{
+			name:  name + v.Suffix,
+			value: v.Value,
+			count: v.Kind == api.COUNT,
+		})
+	}
+
+	return points
+}
+
+// pointValues returns every value of a data point, using the registry where
+// the metric is defined and its shape otherwise, followed by the derived
+// metrics. Only the metrics requested by the query are included.
+func pointValues(q *query, metrics *api.Metrics) ([]pointValue, error) {
+	fields := make([]string, 0, len(metrics.Raw))
+	for field := range metrics.Raw {
+		if q.requested == nil || q.requested[field] {
+			fields = append(fields, field)
+		}
+	}
+	sort.Strings(fields)
+
+	points := []pointValue{}
+
+	for _, field := range fields {
+		if d, ok := metricRegistry.Lookup(field); ok {
+			values, err := definedValues(d, metrics.Raw[field])
+			if err != nil {
+				return nil, err
+			}
+
+			points = append(points, values...)
+		} else {
+			points = append(points, untypedValues(field, metrics.Raw[field])...)
+		}
+	}
+
+	return append(points, evalDerivedMetrics(points)...), nil
+}
+
+// addMetricValues adds the values of a data point to the entity, as metrics
+// or as a sample event depending on the output of the query, with the
+// dimensions mapped and the static attributes of the query added, along with
+// any alert events raised and anomalies detected in the values. Alerts and
+// anomalies are only evaluated on time series points, not on the window
+// totals. It returns the number of metrics and sample events added for the
+// values themselves.
+func addMetricValues(
+	entity     *integration.Entity,
+	q          *query,
+	timestamp  time.Time,
+	dimensions []api.Dimension,
+	metrics    *api.Metrics,
+	timeSeries bool,
+) (int, error) {
+	points, err := pointValues(q, metrics)
+	if err != nil {
+		return 0, err
+	}
+
+	attributes := append(
+		metricMappings.mapDimensions(dimensions),
+		q.attributes...,
+	)
+
+	if timeSeries {
+		err = addAlertEvents(entity, timestamp, dimensions, attributes, points)
+		if err != nil {
+			return 0, err
+		}
+
+		err = detectAnomalies(
+			entity,
+			q,
+			timestamp,
+			dimensions,
+			attributes,
+			points,
+		)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	dimensions = attributes
+	before := len(entity.Metrics) + len(entity.Events)
+
+	if q.events {
+		err = addSampleEvent(entity, timestamp, dimensions, points)
+		return len(entity.Metrics) + len(entity.Events) - before, err
+	}
+
+	for _, p := range points {
+		if p.count {
+			err = newCountMetric(
+				entity,
+				timestamp,
+				p.name,
+				int64(p.value),
+				dimensions,
+			)
+		} else if p.unit != "" && unitsConfig.Attribute {
+			err = newGaugeMetric(
+				entity,
+				timestamp,
+				p.name,
+				p.value,
+				append(
+					dimensions[:len(dimensions):len(dimensions)],
+					api.Dimension{Key: UNIT_ATTRIBUTE, Value: string(p.unit)},
+				),
+			)
+		} else {
+			err = newGaugeMetric(
+				entity,
+				timestamp,
+				p.name,
+				p.value,
+				dimensions,
+			)
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return len(entity.Metrics) + len(entity.Events) - before, nil
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestNewMetricRegistryOverrides(t *testing.T) {
+	r, err := newMetricRegistry([]MetricDefinition{
+		{Field: "plays", Type: METRIC_TYPE_COUNT},
+		{Field: "my-custom-metric", Name: "custom", Type: METRIC_TYPE_GAUGE, Scale: 0.001},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d, ok := r.Lookup("plays")
+	if !ok || d.Type != METRIC_TYPE_COUNT {
+		t.Errorf("plays was not overridden: %+v", d)
+	}
+
+	d, ok = r.Lookup("my_custom_metric")
+	if !ok {
+		t.Fatal("custom metric was not registered")
+	}
+
+	want := MetricDefinition{
+		Field: "my_custom_metric",
+		Name:  "custom",
+		Type:  METRIC_TYPE_GAUGE,
+		Scale: 0.001,
+	}
+	if *d != want {
+		t.Errorf("got %+v, want %+v", *d, want)
+	}
+
+	if got, want := len(r.Definitions()), len(defaultMetricDefinitions) + 1; got != want {
+		t.Errorf("got %d definitions, want %d", got, want)
+	}
+}
+
+func TestNewMetricRegistryErrors(t *testing.T) {
+	tests := []MetricDefinition{
+		{Type: METRIC_TYPE_COUNT},
+		{Field: "plays", Type: "histogram"},
+		{Field: "plays", Type: METRIC_TYPE_GAUGE, Unit: "furlongs"},
+	}
+
+	for _, d := range tests {
+		if _, err := newMetricRegistry([]MetricDefinition{d}); err == nil {
+			t.Errorf("expected an error for %+v", d)
+		}
+	}
+}
+
+func TestMetricRegistryLookup(t *testing.T) {
+	r, err := newMetricRegistry(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"video-start-failures", "video_start_failures"} {
+		d, ok := r.Lookup(name)
+		if !ok || d.Field != "video_start_failures" {
+			t.Errorf("Lookup(%q) = %+v, %v", name, d, ok)
+		}
+	}
+
+	if _, ok := r.Lookup("no-such-metric"); ok {
+		t.Error("found an unregistered metric")
+	}
+
+	// exits_before_ad_start is emitted as ad_exit_before_video_starts.
+	for _, name := range []string{"exits-before-ad-start", "exits_before_ad_start"} {
+		d, ok := r.Lookup(name)
+		if !ok || d.Name != "ad_exit_before_video_starts" {
+			t.Errorf("Lookup(%q) = %+v, %v", name, d, ok)
+		}
+	}
+}
+
+func TestDefinedValues(t *testing.T) {
+	units := unitsConfig
+	defer func() {
+		unitsConfig = units
+	}()
+	unitsConfig = &UnitsConfig{}
+
+	tests := []struct {
+		typ  MetricType
+		raw  string
+		want []pointValue
+	}{
+		{
+			METRIC_TYPE_COUNT,
+			`{"count": 7}`,
+			[]pointValue{{name: "m", value: 7, count: true}},
+		},
+		{
+			METRIC_TYPE_GAUGE,
+			`{"value": 1.5}`,
+			[]pointValue{{name: "m", value: 1.5}},
+		},
+		{
+			METRIC_TYPE_PERCENTAGE,
+			`{"percentage": 12.5}`,
+			[]pointValue{{name: "m", value: 12.5, unit: UNIT_PERCENT}},
+		},
+		{
+			METRIC_TYPE_COUNT_PERCENTAGE,
+			`{"count": 3, "percentage": 30}`,
+			[]pointValue{
+				{name: "m", value: 3, count: true},
+				{name: "m.percentage", value: 30, unit: UNIT_PERCENT},
+			},
+		},
+		{
+			METRIC_TYPE_RATIO,
+			`{"ratio": 0.02}`,
+			[]pointValue{{name: "m", value: 0.02, unit: UNIT_FRACTION}},
+		},
+		{
+			METRIC_TYPE_BITRATE,
+			`{"bps": 3000000}`,
+			[]pointValue{{name: "m", value: 3000000, unit: UNIT_BPS}},
+		},
+		{
+			METRIC_TYPE_FRAMERATE,
+			`{"fps": 29.97}`,
+			[]pointValue{{name: "m", value: 29.97, unit: UNIT_FPS}},
+		},
+		{
+			METRIC_TYPE_ENDED_PLAYS,
+			`{"count": 10, "per_unique_device": 2.5}`,
+			[]pointValue{
+				{name: "m", value: 10, count: true},
+				{name: "m.per_unique_device", value: 2.5},
+			},
+		},
+		{
+			METRIC_TYPE_MINUTES_PLAYED,
+			`{"count": 600, "per_ended_play": 60}`,
+			[]pointValue{
+				{name: "m", value: 600, count: true},
+				{name: "m.per_ended_play", value: 60, unit: UNIT_MINUTES},
+			},
+		},
+		// A value missing from a metric that is present is reported as zero.
+		{
+			METRIC_TYPE_COUNT_PERCENTAGE,
+			`{"count": 3}`,
+			[]pointValue{
+				{name: "m", value: 3, count: true},
+				{name: "m.percentage", value: 0, unit: UNIT_PERCENT},
+			},
+		},
+	}
+
+	if len(tests) - 1 != len(metricTypeValues) {
+		t.Errorf("got tests for %d metric types, want %d", len(tests) - 1, len(metricTypeValues))
+	}
+
+	for _, tt := range tests {
+		d := &MetricDefinition{Field: "m", Name: "m", Type: tt.typ, Scale: 1}
+
+		got, err := definedValues(d, json.RawMessage(tt.raw))
+		if err != nil {
+			t.Errorf("%s: %v", tt.typ, err)
+			continue
+		}
+
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %+v, want %+v", tt.typ, got, tt.want)
+		}
+	}
+}
+
+func TestDefinedValuesScale(t *testing.T) {
+	d := &MetricDefinition{Field: "m", Name: "m", Type: METRIC_TYPE_GAUGE, Scale: 0.5}
+
+	got, err := definedValues(d, json.RawMessage(`{"value": 8}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []pointValue{{name: "m", value: 4}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
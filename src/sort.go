@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/newrelic/nri-conviva/src/api"
+)
+
+// SortConfig sorts the dimension values of a dimensional query by the value
+// of a metric, in descending order unless Order is asc.
+type SortConfig struct {
+	Metric string `yaml:"metric"`
+	Order  string `yaml:"order"`
+}
+
+func checkSortOptions(cfg *Config) error {
+	derived := map[string]bool{}
+	for _, d := range cfg.Derived {
+		derived[d.Name] = true
+	}
+
+	for i := 0; i < len(cfg.Metrics); i += 1 {
+		m := &cfg.Metrics[i]
+
+		if m.Limit < 0 {
+			return fmt.Errorf("limit for %s must not be negative", m.Name())
+		}
+
+		if m.Sort == nil {
+			continue
+		}
+
+		if m.Sort.Metric == "" {
+			return fmt.Errorf("sort for %s has no metric", m.Name())
+		}
+
+		// Conviva sorts by a metric id, which is also the only name the
+		// integration can read the sort value of from the response.
+		if strings.Contains(m.Sort.Metric, ".") || derived[m.Sort.Metric] {
+			return fmt.Errorf(
+				"sort metric %s for %s must be a Conviva metric, not a value of one or a derived metric",
+				m.Sort.Metric,
+				m.Name(),
+			)
+		}
+
+		switch m.Sort.Order {
+		case "":
+			m.Sort.Order = api.SORT_DESC
+		case api.SORT_ASC, api.SORT_DESC:
+		default:
+			return fmt.Errorf(
+				"unknown sort order %q for %s",
+				m.Sort.Order,
+				m.Name(),
+			)
+		}
+	}
+
+	return nil
+}
+
+// queryOptions returns the optional query parameters of the metric
+// definition. Sorting and limits only apply to dimensional queries, and are
+// left to trimDimensionalData when filters are applied by the integration, as
+// the top N returned by Conviva would otherwise be filtered down to fewer.
+func (m *ConfigMetric) queryOptions(dimensional bool) api.QueryOptions {
+	options := api.QueryOptions{SavedFilter: m.SavedFilter}
+
+	if !dimensional {
+		return options
+	}
+
+	if _, client := splitFilters(m.Filters); len(client) > 0 {
+		return options
+	}
+
+	if m.Sort != nil {
+		options.SortBy = m.Sort.Metric
+		options.SortOrder = m.Sort.Order
+	}
+
+	options.Limit = m.Limit
+
+	return options
+}
+
+// sortValue returns the value a dimension value is sorted by, which is the
+// first value of the sort metric as it would be emitted, e.g. the count of a
+// metric with a count and a percentage.
+func sortValue(d *api.DimensionalData, metric string) (float64, bool) {
+	raw, ok := d.Metrics.Raw[fieldName(metric)]
+	if !ok {
+		return 0, false
+	}
+
+	values, err := api.DecodeMetricValues(raw)
+	if err != nil || len(values) == 0 {
+		return 0, false
+	}
+
+	return values[0].Value, true
+}
+
+// trimDimensionalData sorts and limits the dimension values of a data point
+// on the client in case Conviva did not, so that the number of time series
+// emitted is bounded. Dimension values without the sort metric come last.
+func trimDimensionalData(
+	data []api.DimensionalData,
+	s    *SortConfig,
+	limit int,
+) []api.DimensionalData {
+	if s != nil {
+		data = append([]api.DimensionalData{}, data...)
+
+		sort.SliceStable(data, func(i, j int) bool {
+			vi, iok := sortValue(&data[i], s.Metric)
+			vj, jok := sortValue(&data[j], s.Metric)
+
+			if !iok || !jok {
+				return iok && !jok
+			}
+
+			if s.Order == api.SORT_ASC {
+				return vi < vj
+			}
+
+			return vi > vj
+		})
+	}
+
+	if limit > 0 && len(data) > limit {
+		data = data[:limit]
+	}
+
+	return data
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/newrelic/nri-conviva/src/api"
+)
+
+func TestQueryOptions(t *testing.T) {
+	sort := &SortConfig{Metric: "plays", Order: api.SORT_DESC}
+
+	tests := []struct {
+		metric      ConfigMetric
+		dimensional bool
+		want        api.QueryOptions
+	}{
+		{
+			ConfigMetric{Sort: sort, Limit: 10, SavedFilter: "42"},
+			true,
+			api.QueryOptions{SortBy: "plays", SortOrder: api.SORT_DESC, Limit: 10, SavedFilter: "42"},
+		},
+		{
+			ConfigMetric{Sort: sort, Limit: 10, SavedFilter: "42"},
+			false,
+			api.QueryOptions{SavedFilter: "42"},
+		},
+		{
+			ConfigMetric{
+				Sort:    sort,
+				Limit:   10,
+				Filters: map[string][]string{"device-name": {"Roku", "Samsung"}},
+			},
+			true,
+			api.QueryOptions{SortBy: "plays", SortOrder: api.SORT_DESC, Limit: 10},
+		},
+		{
+			ConfigMetric{
+				Sort:    sort,
+				Limit:   10,
+				Filters: map[string][]string{"device-name": {"!QA"}},
+			},
+			true,
+			api.QueryOptions{},
+		},
+		{
+			ConfigMetric{
+				Limit:   10,
+				Filters: map[string][]string{"device-name": {"Samsung*"}},
+			},
+			true,
+			api.QueryOptions{},
+		},
+	}
+
+	for _, tt := range tests {
+		if got := tt.metric.queryOptions(tt.dimensional); got != tt.want {
+			t.Errorf("queryOptions(%v) = %+v, want %+v", tt.dimensional, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DEFAULT_MAX_LOOKBACK = 24 * time.Hour
+)
+
+type QueryState struct {
+	LastTimestamp int64 `json:"lastTimestamp"`
+}
+
+// State records, for each query, the timestamp of the last data point that
+// was emitted so that consecutive runs neither double count nor leave gaps.
+type State struct {
+	Queries     map[string]*QueryState     `json:"queries"`
+	Backfill    *BackfillState             `json:"backfill,omitempty"`
+	Anomalies   map[string]*AnomalyHistory `json:"anomalies,omitempty"`
+	path        string
+	maxLookback time.Duration
+	mu          sync.Mutex
+}
+
+func loadState(path string, maxLookback string) (*State, error) {
+	var err error
+
+	state := &State{
+		Queries:     map[string]*QueryState{},
+		path:        path,
+		maxLookback: DEFAULT_MAX_LOOKBACK,
+	}
+
+	if maxLookback != "" {
+		state.maxLookback, err = time.ParseDuration(maxLookback)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return state, nil
+		}
+		return nil, err
+	}
+
+	err = json.Unmarshal(data, state)
+	if err != nil {
+		return nil, err
+	}
+
+	if state.Queries == nil {
+		state.Queries = map[string]*QueryState{}
+	}
+
+	return state, nil
+}
+
+// save writes the state to a temporary file and renames it into place so that
+// a crash part way through never leaves a truncated state file behind. The
+// anomaly detection histories of series not seen within the maximum lookback
+// are dropped first.
+func (s *State) save() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	pruneAnomalyHistories(s.Anomalies, time.Now().Add(-s.maxLookback))
+
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path) + ".*")
+	if err != nil {
+		return err
+	}
+
+	defer os.Remove(tmp.Name())
+
+	if _, err = tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), s.path)
+}
+
+func (s *State) mark(key string) (int64, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	q, ok := s.Queries[key]
+	if !ok {
+		return 0, false
+	}
+
+	return q.LastTimestamp, true
+}
+
+func (s *State) update(key string, timestamp int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	q, ok := s.Queries[key]
+	if !ok {
+		s.Queries[key] = &QueryState{LastTimestamp: timestamp}
+	} else if timestamp > q.LastTimestamp {
+		q.LastTimestamp = timestamp
+	}
+}
+
+// lookback returns how far back a query should start so that it picks up from
+// the given mark, capped at the maximum lookback.
+func (s *State) lookback(mark int64) time.Duration {
+	d := time.Since(time.UnixMilli(mark))
+	if d > s.maxLookback {
+		d = s.maxLookback
+	}
+
+	// Round up so the window always starts at or before the mark. Points at
+	// or before the mark are skipped when they are emitted.
+	return (d + time.Second).Truncate(time.Second)
+}
+
+// stateKey identifies a query across runs by everything that determines which
+// time series it returns.
+func stateKey(m *ConfigMetric, dimension string, granularity string) string {
+	parts := []string{m.Name(), dimension, granularity}
+
+	keys := make([]string, 0, len(m.Filters))
+	for k := range m.Filters {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		parts = append(parts, k + "=" + strings.Join(m.Filters[k], ","))
+	}
+
+	if m.SavedFilter != "" {
+		parts = append(parts, "savedFilter=" + m.SavedFilter)
+	}
+
+	return strings.Join(parts, "|")
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/url"
+	"strconv"
+	"time"
+
+	sdk_metric "github.com/newrelic/infra-integrations-sdk/v4/data/metric"
+	"github.com/newrelic/infra-integrations-sdk/v4/integration"
+	"github.com/newrelic/nri-conviva/src/api"
+)
+
+const (
+	TELEMETRY_PREFIX = METRIC_PREFIX + "integration."
+)
+
+// queryTelemetry holds the self-telemetry gathered for a single query.
+type queryTelemetry struct {
+	stats      api.RequestStats
+	dataPoints int
+	errorType  string
+}
+
+// errorType classifies a query error for the errors telemetry metric.
+func errorType(err error) string {
+	var (
+		authErr       *api.AuthError
+		rateLimitErr  *api.RateLimitError
+		badRequestErr *api.BadRequestError
+		serverErr     *api.ServerError
+		apiErr        *api.APIError
+		urlErr        *url.Error
+		syntaxErr     *json.SyntaxError
+		typeErr       *json.UnmarshalTypeError
+	)
+
+	switch {
+	case errors.Is(err, errQuerySkipped):
+		return "skipped"
+	case errors.As(err, &authErr):
+		return "auth"
+	case errors.As(err, &rateLimitErr):
+		return "rate_limit"
+	case errors.As(err, &badRequestErr):
+		return "bad_request"
+	case errors.As(err, &serverErr):
+		return "server"
+	case errors.As(err, &apiErr):
+		return "api"
+	case errors.As(err, &urlErr):
+		return "transport"
+	case errors.As(err, &syntaxErr), errors.As(err, &typeErr):
+		return "decode"
+	}
+
+	return "other"
+}
+
+func requestStats(r *queryResult) api.RequestStats {
+	var reqErr *api.RequestError
+
+	if r.metricData != nil {
+		return r.metricData.Stats
+	} else if r.dimMetricData != nil {
+		return r.dimMetricData.Stats
+	} else if errors.As(r.err, &reqErr) {
+		return reqErr.Stats
+	}
+
+	return api.RequestStats{}
+}
+
+func newTelemetryMetric(
+	entity     *integration.Entity,
+	metric     sdk_metric.Metric,
+	attributes map[string]string,
+) error {
+	for k, v := range attributes {
+		err := metric.AddDimension(k, v)
+		if err != nil {
+			return err
+		}
+	}
+
+	entity.AddMetric(metric)
+
+	return nil
+}
+
+func queryAttributes(q *query) map[string]string {
+	attributes := map[string]string{
+		"query":       q.metric.Name(),
+		"query.index": strconv.Itoa(q.index),
+	}
+
+	if q.metric.MetricGroup != "" {
+		attributes["query.metricGroup"] = q.metric.MetricGroup
+	}
+
+	if len(q.dimensions) > 0 {
+		attributes["query.dimension"] = q.dimensions.String()
+	}
+
+	return attributes
+}
+
+// addQueryTelemetry emits the conviva.integration.* metrics for one query.
+func addQueryTelemetry(
+	entity    *integration.Entity,
+	timestamp time.Time,
+	q         *query,
+	t         *queryTelemetry,
+) error {
+	attributes := queryAttributes(q)
+
+	queryMetrics := []struct {
+		name  string
+		count bool
+		value float64
+	}{
+		{"requests", true, float64(t.stats.Requests)},
+		{"datapoints", true, float64(t.dataPoints)},
+		{"request.duration", false, float64(t.stats.RequestDuration.Milliseconds())},
+		{"unmarshal.duration", false, float64(t.stats.UnmarshalDuration.Milliseconds())},
+		{"ratelimit.wait", false, float64(t.stats.RateLimitWait.Milliseconds())},
+		{"retry.wait", false, float64(t.stats.RetryWait.Milliseconds())},
+		{"response.bytes", false, float64(t.stats.ResponseBytes)},
+	}
+
+	for _, m := range queryMetrics {
+		var (
+			metric sdk_metric.Metric
+			err    error
+		)
+
+		if m.count {
+			metric, err = sdk_metric.NewCount(
+				timestamp,
+				TELEMETRY_PREFIX + m.name,
+				m.value,
+			)
+		} else {
+			metric, err = sdk_metric.NewGauge(
+				timestamp,
+				TELEMETRY_PREFIX + m.name,
+				m.value,
+			)
+		}
+		if err != nil {
+			return err
+		}
+
+		err = newTelemetryMetric(entity, metric, attributes)
+		if err != nil {
+			return err
+		}
+	}
+
+	if t.errorType != "" {
+		metric, err := sdk_metric.NewCount(
+			timestamp,
+			TELEMETRY_PREFIX + "errors",
+			1,
+		)
+		if err != nil {
+			return err
+		}
+
+		attributes["error.type"] = t.errorType
+
+		return newTelemetryMetric(entity, metric, attributes)
+	}
+
+	return nil
+}
+
+// addRunTelemetry emits the conviva.integration.* metrics for the whole run.
+func addRunTelemetry(
+	entity    *integration.Entity,
+	timestamp time.Time,
+	queries   int,
+	failed    int,
+) error {
+	runMetrics := []struct {
+		name  string
+		value float64
+	}{
+		{"run.duration", float64(time.Since(timestamp).Milliseconds())},
+		{"queries", float64(queries)},
+		{"queries.failed", float64(failed)},
+	}
+
+	for _, m := range runMetrics {
+		metric, err := sdk_metric.NewGauge(
+			timestamp,
+			TELEMETRY_PREFIX + m.name,
+			m.value,
+		)
+		if err != nil {
+			return err
+		}
+
+		entity.AddMetric(metric)
+	}
+
+	return nil
+}
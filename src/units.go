@@ -0,0 +1,165 @@
+package main
+
+import (
+	"fmt"
+)
+
+type Unit string
+
+const (
+	UNIT_BPS          Unit = "bps"
+	UNIT_KBPS         Unit = "kbps"
+	UNIT_MBPS         Unit = "mbps"
+	UNIT_MILLISECONDS Unit = "ms"
+	UNIT_SECONDS      Unit = "s"
+	UNIT_MINUTES      Unit = "min"
+	UNIT_PERCENT      Unit = "percent"
+	UNIT_FRACTION     Unit = "fraction"
+	UNIT_FPS          Unit = "fps"
+	UNIT_ATTRIBUTE         = "unit"
+)
+
+// unitFactor relates a unit to the canonical unit of its family. A value in
+// the unit is converted to the canonical unit by multiplying it by multiply
+// and dividing it by divide, which avoids inexact factors such as 0.01.
+type unitFactor struct {
+	canonical Unit
+	multiply  float64
+	divide    float64
+}
+
+var unitFactors = map[Unit]unitFactor{
+	UNIT_BPS:          {UNIT_KBPS, 1, 1000},
+	UNIT_KBPS:         {UNIT_KBPS, 1, 1},
+	UNIT_MBPS:         {UNIT_KBPS, 1000, 1},
+	UNIT_MILLISECONDS: {UNIT_SECONDS, 1, 1000},
+	UNIT_SECONDS:      {UNIT_SECONDS, 1, 1},
+	UNIT_MINUTES:      {UNIT_SECONDS, 60, 1},
+	UNIT_PERCENT:      {UNIT_FRACTION, 1, 100},
+	UNIT_FRACTION:     {UNIT_FRACTION, 1, 1},
+	UNIT_FPS:          {UNIT_FPS, 1, 1},
+}
+
+// UnitOverride replaces the unit Conviva reports a metric in (From) and the
+// unit it is emitted in (To).
+type UnitOverride struct {
+	From Unit `yaml:"from"`
+	To   Unit `yaml:"to"`
+}
+
+// UnitsConfig controls unit normalization. When Normalize is set, gauge values
+// are converted to the canonical unit of their family, kbps for bitrates,
+// seconds for times and a fraction for ratios and percentages. When
+// Attribute is set, a unit attribute with the emitted unit is added.
+type UnitsConfig struct {
+	Normalize bool                    `yaml:"normalize"`
+	Attribute bool                    `yaml:"attribute"`
+	Metrics   map[string]UnitOverride `yaml:"metrics"`
+}
+
+var (
+	unitsConfig = &UnitsConfig{}
+)
+
+func validUnit(unit Unit) bool {
+	_, ok := unitFactors[unit]
+	return ok
+}
+
+// initUnits checks that every override names a registered metric and known
+// units that can be converted between.
+func initUnits(cfg *Config, r *MetricRegistry) error {
+	u := cfg.Units
+	u.Metrics = map[string]UnitOverride{}
+
+	for field, o := range cfg.Units.Metrics {
+		if o.From != "" && !validUnit(o.From) {
+			return fmt.Errorf("unknown unit %q for metric %s", o.From, field)
+		}
+
+		if o.To != "" && !validUnit(o.To) {
+			return fmt.Errorf("unknown unit %q for metric %s", o.To, field)
+		}
+
+		d, ok := r.Lookup(field)
+		if !ok {
+			return fmt.Errorf("unit override for unknown metric %s", field)
+		}
+
+		u.Metrics[d.Field] = o
+
+		for _, v := range metricTypeValues[d.Type] {
+			if v.count {
+				continue
+			}
+
+			_, _, err := u.normalizeUnit(d.Field, 0, d.valueUnit(v))
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	unitsConfig = &u
+
+	return nil
+}
+
+// convertUnit converts a value between two units of the same family.
+func convertUnit(value float64, from, to Unit) (float64, error) {
+	f, ok := unitFactors[from]
+	if !ok {
+		return 0, fmt.Errorf("unknown unit %q", from)
+	}
+
+	t, ok := unitFactors[to]
+	if !ok {
+		return 0, fmt.Errorf("unknown unit %q", to)
+	}
+
+	if f.canonical != t.canonical {
+		return 0, fmt.Errorf("cannot convert %s to %s", from, to)
+	}
+
+	return value * f.multiply / f.divide * t.divide / t.multiply, nil
+}
+
+// normalizeUnit returns a gauge value of a registered metric, reported by
+// Conviva in the given unit, in the unit it is emitted in, along with that
+// unit. Values without a unit are returned unchanged.
+func (u *UnitsConfig) normalizeUnit(
+	field string,
+	value float64,
+	unit Unit,
+) (float64, Unit, error) {
+	o := u.Metrics[field]
+
+	if o.From != "" {
+		unit = o.From
+	}
+
+	if unit == "" {
+		if o.To != "" {
+			return 0, "", fmt.Errorf(
+				"metric %s has no unit to convert from",
+				field,
+			)
+		}
+		return value, unit, nil
+	}
+
+	to := o.To
+	if to == "" {
+		if !u.Normalize {
+			return value, unit, nil
+		}
+		to = unitFactors[unit].canonical
+	}
+
+	value, err := convertUnit(value, unit, to)
+	if err != nil {
+		return 0, "", fmt.Errorf("metric %s: %v", field, err)
+	}
+
+	return value, to, nil
+}
@@ -0,0 +1,694 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/newrelic/nri-conviva/src/api"
+)
+
+var (
+	yamlErrorRegex = regexp.MustCompile(`^(?:yaml: )?line (\d+): (.*)$`)
+
+	// knownDimensions and knownMetricGroups are the Conviva dimensions and
+	// metric groups the validator knows of, compared by their dimensionKey.
+	// What is available depends on the account, so other names are only
+	// warned about.
+	knownDimensions = map[string]bool{
+		"asn":                             true,
+		"browser_name":                    true,
+		"browser_version":                 true,
+		"cdn":                             true,
+		"channel":                         true,
+		"city":                            true,
+		"connection_type":                 true,
+		"country":                         true,
+		"device_hardware_type":            true,
+		"device_manufacturer":             true,
+		"device_marketing_name":           true,
+		"device_model":                    true,
+		"device_name":                     true,
+		"device_operating_system":         true,
+		"device_operating_system_version": true,
+		"dma":                             true,
+		"geo_country_code":                true,
+		"isp":                             true,
+		"player_name":                     true,
+		"state":                           true,
+		"stream_type":                     true,
+	}
+	knownMetricGroups = map[string]bool{
+		"audience_metriclens": true,
+		"quality_metriclens":  true,
+		"quality_summary":     true,
+	}
+)
+
+// configError is a problem found in the configuration file along with the
+// line and path of the value it was found in. A warning is a problem that does
+// not prevent the integration from running, such as a metric that is not in
+// the metric registry.
+type configError struct {
+	line    int
+	path    string
+	err     error
+	warning bool
+}
+
+func (e *configError) Error() string {
+	msg := e.err.Error()
+
+	if e.path != "" {
+		msg = e.path + ": " + msg
+	}
+
+	if e.warning {
+		msg = "warning: " + msg
+	}
+
+	if e.line > 0 {
+		return fmt.Sprintf("line %d: %s", e.line, msg)
+	}
+
+	return msg
+}
+
+// configValidator collects every problem in a configuration instead of
+// stopping at the first one. Values are located in the YAML document by their
+// path of mapping keys and list indexes.
+type configValidator struct {
+	root   *yaml.Node
+	errors []*configError
+}
+
+func configPathString(path []interface{}) string {
+	var b strings.Builder
+
+	for _, p := range path {
+		switch k := p.(type) {
+		case int:
+			fmt.Fprintf(&b, "[%d]", k)
+		default:
+			if b.Len() > 0 {
+				b.WriteString(".")
+			}
+			fmt.Fprint(&b, k)
+		}
+	}
+
+	return b.String()
+}
+
+// line returns the line of the value at the path, or of the closest
+// enclosing value when it is not set. Mapping values are located by the line
+// of their key.
+func (v *configValidator) line(path ...interface{}) int {
+	n := v.root
+	if n == nil {
+		return 0
+	}
+
+	line := n.Line
+
+	for _, p := range path {
+		var next *yaml.Node
+
+		switch k := p.(type) {
+		case string:
+			if n.Kind != yaml.MappingNode {
+				return line
+			}
+
+			for i := 0; i + 1 < len(n.Content); i += 2 {
+				if n.Content[i].Value == k {
+					line = n.Content[i].Line
+					next = n.Content[i + 1]
+					break
+				}
+			}
+		case int:
+			if n.Kind != yaml.SequenceNode || k >= len(n.Content) {
+				return line
+			}
+
+			next = n.Content[k]
+			line = next.Line
+		}
+
+		if next == nil {
+			return line
+		}
+
+		n = next
+	}
+
+	return line
+}
+
+func (v *configValidator) report(err error, path ...interface{}) {
+	v.errors = append(v.errors, &configError{
+		line: v.line(path...),
+		path: configPathString(path),
+		err:  err,
+	})
+}
+
+func (v *configValidator) warn(err error, path ...interface{}) {
+	v.errors = append(v.errors, &configError{
+		line:    v.line(path...),
+		path:    configPathString(path),
+		err:     err,
+		warning: true,
+	})
+}
+
+// warnUnregistered warns about a metric that is not in the metric registry.
+// Such metrics are still collected, with their values emitted based on their
+// shape, as they may be custom metrics or metrics added by Conviva since.
+func (v *configValidator) warnUnregistered(name string, path ...interface{}) {
+	v.warn(
+		fmt.Errorf(
+			"metric %s is not in the metric registry, so its values are emitted based on their shape",
+			name,
+		),
+		path...,
+	)
+}
+
+// warnUnknown warns about a dimension or metric group that is not in the
+// known list, as Conviva returns no data for a name it does not have. Names
+// that are not valid identifiers are reported by checkNames instead.
+func (v *configValidator) warnUnknown(
+	kind  string,
+	name  string,
+	known map[string]bool,
+	path  ...interface{},
+) {
+	if known[dimensionKey(name)] || api.ValidateIdentifier(kind, name) != nil {
+		return
+	}
+
+	v.warn(
+		fmt.Errorf(
+			"%s %s is not a known Conviva %s, so it may return no data; run with -list to see those of the account",
+			kind,
+			name,
+			kind,
+		),
+		path...,
+	)
+}
+
+// reportYAMLError reports a syntax or decoding error, which carries its own
+// line numbers.
+func (v *configValidator) reportYAMLError(err error) {
+	var typeErr *yaml.TypeError
+
+	messages := []string{err.Error()}
+	if errors.As(err, &typeErr) {
+		messages = typeErr.Errors
+	}
+
+	for _, msg := range messages {
+		e := &configError{err: errors.New(msg)}
+
+		if match := yamlErrorRegex.FindStringSubmatch(msg); match != nil {
+			e.line, _ = strconv.Atoi(match[1])
+			e.err = errors.New(match[2])
+		}
+
+		v.errors = append(v.errors, e)
+	}
+}
+
+// checkDuration parses a Go duration, returning the fallback when the value
+// is not set and false when it is invalid.
+func (v *configValidator) checkDuration(
+	value    string,
+	fallback time.Duration,
+	path     ...interface{},
+) (time.Duration, bool) {
+	if value == "" {
+		return fallback, true
+	}
+
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		v.report(err, path...)
+		return fallback, false
+	}
+
+	return d, true
+}
+
+func (v *configValidator) checkInterval(value string, path ...interface{}) {
+	d, ok := v.checkDuration(value, DEFAULT_DAEMON_INTERVAL, path...)
+	if ok && d <= 0 {
+		v.report(fmt.Errorf("interval %s must be greater than 0", d), path...)
+	}
+}
+
+func (v *configValidator) checkGranularity(value string, path ...interface{}) {
+	if value == "" {
+		return
+	}
+
+	if _, err := api.ParseISO8601Duration(value); err != nil {
+		v.report(err, path...)
+	}
+}
+
+// checkTimeRange checks that the end offset is not further back than the
+// start offset, as the API client does before sending a request.
+func (v *configValidator) checkTimeRange(
+	start time.Duration,
+	end   time.Duration,
+	path  ...interface{},
+) {
+	if start != 0 && end > start {
+		v.report(
+			fmt.Errorf("end offset %s is more than start offset %s", end, start),
+			path...,
+		)
+	}
+}
+
+// validateConfigFile checks the whole configuration file without calling the
+// Conviva API and returns every problem found in it, ordered by line.
+func validateConfigFile(configPath string) ([]*configError, error) {
+	raw, err := os.ReadFile(configPath)
+	if err != nil {
+		return nil, err
+	}
+
+	v := &configValidator{}
+
+	doc := yaml.Node{}
+
+	if err = yaml.Unmarshal(raw, &doc); err != nil {
+		v.reportYAMLError(err)
+		return v.errors, nil
+	}
+
+	if len(doc.Content) > 0 {
+		v.root = doc.Content[0]
+	}
+
+	cfg := &Config{}
+
+	dec := yaml.NewDecoder(bytes.NewReader(raw))
+	dec.KnownFields(true)
+
+	if err = dec.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+		v.reportYAMLError(err)
+	}
+
+	applyDefaults(cfg)
+
+	v.checkConfig(cfg)
+
+	sort.SliceStable(v.errors, func(i, j int) bool {
+		return v.errors[i].line < v.errors[j].line
+	})
+
+	return v.errors, nil
+}
+
+func (v *configValidator) checkConfig(cfg *Config) {
+	u, err := url.Parse(cfg.ApiV3URL)
+	if err != nil ||
+		(u.Scheme != "http" && u.Scheme != "https") ||
+		u.Host == "" {
+		v.report(fmt.Errorf("invalid URL %q", cfg.ApiV3URL), "apiV3Url")
+	}
+
+	start, startOk := v.checkDuration(cfg.StartOffset, 0, "startOffset")
+	end, endOk := v.checkDuration(cfg.EndOffset, 0, "endOffset")
+
+	if startOk && endOk {
+		v.checkTimeRange(start, end, "endOffset")
+	}
+
+	v.checkGranularity(cfg.Granularity, "granularity")
+
+	if _, err = api.NewRetryPolicy(cfg.RetryMaxAttempts, "", "", nil); err != nil {
+		v.report(err, "retryMaxAttempts")
+	}
+
+	_, baseOk := v.checkDuration(cfg.RetryBaseBackoff, 0, "retryBaseBackoff")
+	_, maxOk := v.checkDuration(cfg.RetryMaxBackoff, 0, "retryMaxBackoff")
+
+	if baseOk && maxOk {
+		_, err = api.NewRetryPolicy(
+			0,
+			cfg.RetryBaseBackoff,
+			cfg.RetryMaxBackoff,
+			nil,
+		)
+		if err != nil {
+			v.report(err, "retryMaxBackoff")
+		}
+	}
+
+	if _, err = api.NewRetryPolicy(0, "", "", cfg.RetryJitter); err != nil {
+		v.report(err, "retryJitter")
+	}
+
+	if cfg.RateLimit < 0 {
+		v.report(
+			fmt.Errorf("rate limit %f must not be negative", cfg.RateLimit),
+			"rateLimit",
+		)
+	}
+
+	if _, err = api.NewRateLimiter(1, cfg.RateLimitBurst); err != nil {
+		v.report(err, "rateLimitBurst")
+	}
+
+	if cfg.Concurrency < 0 {
+		v.report(
+			fmt.Errorf("concurrency %d must not be negative", cfg.Concurrency),
+			"concurrency",
+		)
+	}
+
+	v.checkDuration(cfg.MaxLookback, 0, "maxLookback")
+	v.checkInterval(cfg.Interval, "interval")
+
+	r := v.checkMetricDefinitions(cfg)
+	derived := v.checkDerivedMetrics(cfg, r)
+
+	// known reports whether a name is a registered metric, a value of one,
+	// such as plays.percentage, or a derived metric.
+	known := func(name string) bool {
+		if _, ok := r.Lookup(name); ok {
+			return true
+		}
+
+		if _, ok := r.Lookup(strings.SplitN(name, ".", 2)[0]); ok {
+			return true
+		}
+
+		return derived[name]
+	}
+
+	v.checkAlerts(cfg, known)
+
+	for i := 0; i < len(cfg.Metrics); i += 1 {
+		v.checkMetric(cfg, i, start, end, r, known)
+	}
+}
+
+// checkMetricDefinitions registers the configured metric definitions and the
+// unit overrides, and returns the resulting registry.
+func (v *configValidator) checkMetricDefinitions(cfg *Config) *MetricRegistry {
+	r, _ := newMetricRegistry(nil)
+
+	for i, d := range cfg.MetricDefinitions {
+		if err := r.Register(d); err != nil {
+			v.report(err, "metricDefinitions", i)
+		}
+	}
+
+	fields := make([]string, 0, len(cfg.Units.Metrics))
+	for field := range cfg.Units.Metrics {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	for _, field := range fields {
+		units := &Config{
+			Units: UnitsConfig{
+				Normalize: cfg.Units.Normalize,
+				Metrics: map[string]UnitOverride{
+					field: cfg.Units.Metrics[field],
+				},
+			},
+		}
+
+		if err := initUnits(units, r); err != nil {
+			v.report(err, "units", "metrics", field)
+		}
+	}
+
+	return r
+}
+
+// checkDerivedMetrics checks the expressions of the derived metrics and
+// returns their names. A derived metric may only use registered metrics and
+// the derived metrics before it.
+func (v *configValidator) checkDerivedMetrics(
+	cfg *Config,
+	r   *MetricRegistry,
+) map[string]bool {
+	derived := map[string]bool{}
+
+	for i, d := range cfg.Derived {
+		if d.Name == "" {
+			v.report(
+				fmt.Errorf("derived metric %q has no name", d.Expression),
+				"derived",
+				i,
+			)
+		}
+
+		e, err := parseExpression(d.Expression)
+		if err != nil {
+			v.report(err, "derived", i, "expression")
+			continue
+		}
+
+		for _, name := range expressionMetrics(e) {
+			_, ok := r.Lookup(strings.SplitN(name, ".", 2)[0])
+			if !ok && !derived[name] {
+				v.warnUnregistered(name, "derived", i, "expression")
+			}
+		}
+
+		if d.Name != "" {
+			derived[d.Name] = true
+		}
+	}
+
+	return derived
+}
+
+func expressionMetrics(e expr) []string {
+	switch e := e.(type) {
+	case metricExpr:
+		return []string{string(e)}
+	case *negateExpr:
+		return expressionMetrics(e.operand)
+	case *binaryExpr:
+		return append(expressionMetrics(e.left), expressionMetrics(e.right)...)
+	}
+
+	return nil
+}
+
+// checkAlerts checks that each alert rule parses, uses a known metric and
+// only matches on dimensions that some metric definition is grouped by.
+func (v *configValidator) checkAlerts(cfg *Config, known func(string) bool) {
+	grouped := map[string]bool{}
+
+	for _, m := range cfg.Metrics {
+		for _, dimensions := range m.Dimensions {
+			for _, d := range dimensions {
+				grouped[dimensionKey(d)] = true
+			}
+		}
+	}
+
+	for i, a := range cfg.Alerts {
+		rule, err := parseAlertRule(a)
+		if err != nil {
+			v.report(err, "alerts", i, "rule")
+			continue
+		}
+
+		if !known(rule.metric) {
+			v.warnUnregistered(rule.metric, "alerts", i, "rule")
+		}
+
+		dimensions := make([]string, 0, len(rule.dimensions))
+		for d := range rule.dimensions {
+			dimensions = append(dimensions, d)
+		}
+		sort.Strings(dimensions)
+
+		for _, d := range dimensions {
+			if !grouped[dimensionKey(d)] {
+				v.report(
+					fmt.Errorf(
+						"alert rule %q matches on dimension %s, which no metric definition is grouped by",
+						a.Rule,
+						d,
+					),
+					"alerts",
+					i,
+					"rule",
+				)
+			}
+		}
+	}
+}
+
+// checkMetric checks a metric definition, whose offsets default to the
+// global start and end offsets. The metrics it queries must be registered,
+// while the metrics it sorts by or detects anomalies in may also be derived.
+func (v *configValidator) checkMetric(
+	cfg   *Config,
+	index int,
+	start time.Duration,
+	end   time.Duration,
+	r     *MetricRegistry,
+	known func(string) bool,
+) {
+	m := &cfg.Metrics[index]
+
+	path := func(p ...interface{}) []interface{} {
+		return append([]interface{}{"metrics", index}, p...)
+	}
+
+	report := func(err error, p ...interface{}) {
+		v.report(err, path(p...)...)
+	}
+
+	set := []string{}
+
+	if m.Metric != "" {
+		set = append(set, "metric")
+	}
+
+	if m.MetricGroup != "" {
+		set = append(set, "metricGroup")
+	}
+
+	if len(m.Names) > 0 {
+		set = append(set, "names")
+	}
+
+	if len(set) == 0 {
+		report(errors.New("one of metric, metricGroup or names must be set"))
+	} else if len(set) > 1 {
+		report(
+			fmt.Errorf("only one of %s may be set", strings.Join(set, ", ")),
+			set[1],
+		)
+	}
+
+	m.checkNames(report)
+
+	if _, ok := r.Lookup(m.Metric); m.Metric != "" && !ok {
+		v.warnUnregistered(m.Metric, path("metric")...)
+	}
+
+	for i, name := range m.Names {
+		if _, ok := r.Lookup(name); !ok {
+			v.warnUnregistered(name, path("names", i)...)
+		}
+	}
+
+	if m.MetricGroup != "" {
+		v.warnUnknown("metric group", m.MetricGroup, knownMetricGroups, path("metricGroup")...)
+	}
+
+	for i, dimensions := range m.Dimensions {
+		for j, d := range dimensions {
+			v.warnUnknown("dimension", d, knownDimensions, path("dimensions", i, j)...)
+		}
+	}
+
+	filterKeys := make([]string, 0, len(m.Filters))
+	for k := range m.Filters {
+		filterKeys = append(filterKeys, k)
+	}
+	sort.Strings(filterKeys)
+
+	for _, k := range filterKeys {
+		v.warnUnknown("dimension", k, knownDimensions, path("filters", k)...)
+	}
+
+	start, startOk := v.checkDuration(m.StartOffset, start, path("startOffset")...)
+	end, endOk := v.checkDuration(m.EndOffset, end, path("endOffset")...)
+
+	if startOk && endOk && m.EndOffset != "" {
+		v.checkTimeRange(start, end, path("endOffset")...)
+	} else if startOk && endOk && m.StartOffset != "" {
+		v.checkTimeRange(start, end, path("startOffset")...)
+	}
+
+	v.checkGranularity(m.Granularity, path("granularity")...)
+	v.checkInterval(m.Interval, path("interval")...)
+
+	single := &Config{Metrics: []ConfigMetric{*m}, Derived: cfg.Derived}
+
+	if err := checkOutputs(single); err != nil {
+		report(err, "output")
+	}
+
+	if err := checkAnomalyConfig(single); err != nil {
+		report(err, "anomaly")
+	} else if m.Anomaly != nil {
+		for i, name := range m.Anomaly.Metrics {
+			if !known(name) {
+				v.warnUnregistered(name, path("anomaly", "metrics", i)...)
+			}
+		}
+	}
+
+	if err := checkSortOptions(single); err != nil {
+		if m.Limit < 0 {
+			report(err, "limit")
+		} else {
+			report(err, "sort")
+		}
+	} else if m.Sort != nil {
+		if _, ok := r.Lookup(m.Sort.Metric); !ok {
+			v.warnUnregistered(m.Sort.Metric, path("sort", "metric")...)
+		}
+	}
+
+	if len(m.Dimensions) == 0 && m.Sort != nil {
+		report(errors.New("sort only applies to metrics with dimensions"), "sort")
+	}
+
+	if len(m.Dimensions) == 0 && m.Limit > 0 {
+		report(errors.New("limit only applies to metrics with dimensions"), "limit")
+	}
+}
+
+// runValidate prints every problem in the configuration file and returns an
+// error when there is any that is not a warning.
+func runValidate(configPath string) error {
+	errs, err := validateConfigFile(configPath)
+	if err != nil {
+		return err
+	}
+
+	invalid := false
+
+	for _, e := range errs {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", configPath, e)
+		invalid = invalid || !e.warning
+	}
+
+	if invalid {
+		return fmt.Errorf("%s is not valid", configPath)
+	}
+
+	fmt.Printf("%s is valid\n", configPath)
+
+	return nil
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeConfig(t *testing.T, config string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "config.yml")
+
+	err := os.WriteFile(path, []byte(strings.TrimLeft(config, "\n")), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+type wantConfigError struct {
+	line    int
+	path    string
+	warning bool
+}
+
+func checkConfigErrors(t *testing.T, errs []*configError, want []wantConfigError) {
+	t.Helper()
+
+	if len(errs) != len(want) {
+		for _, e := range errs {
+			t.Log(e)
+		}
+		t.Fatalf("got %d errors, want %d", len(errs), len(want))
+	}
+
+	for i, w := range want {
+		e := errs[i]
+		if e.line != w.line || e.path != w.path || e.warning != w.warning {
+			t.Errorf(
+				"error %d: got line %d, path %q, warning %v (%v), want line %d, path %q, warning %v",
+				i,
+				e.line,
+				e.path,
+				e.warning,
+				e.err,
+				w.line,
+				w.path,
+				w.warning,
+			)
+		}
+	}
+}
+
+func TestValidateConfigFile(t *testing.T) {
+	path := writeConfig(t, `
+apiV3Url: https://api.conviva.com/insights/3.0
+startOffset: 1h
+endOffset: 2h
+granularity: PT5X
+unknownOption: true
+metrics:
+- metric: plays
+  names: [bitrate]
+- dimensions: [cdn]
+- metric: my_custom_metric
+  startOffset: 10m
+  endOffset: 20m
+  sort:
+    metric: plays
+- metricGroup: quality-metriclens
+  dimensions:
+  - cdn
+  - [device-name, "bad name"]
+  output: table
+  limit: ten
+`)
+
+	errs, err := validateConfigFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checkConfigErrors(t, errs, []wantConfigError{
+		{3, "endOffset", false},
+		{4, "granularity", false},
+		{5, "", false},
+		{8, "metrics[0].names", false},
+		{9, "metrics[1]", false},
+		{10, "metrics[2].metric", true},
+		{12, "metrics[2].endOffset", false},
+		{13, "metrics[2].sort", false},
+		{18, "metrics[3].dimensions[1][1]", false},
+		{19, "metrics[3].output", false},
+		{20, "", false},
+	})
+}
+
+func TestValidateConfigFileLocatesMissingValues(t *testing.T) {
+	path := writeConfig(t, `
+metrics:
+- metric: plays
+  dimensions: [cdn]
+  sort:
+    order: up
+`)
+
+	errs, err := validateConfigFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The sort has no metric, so the error is located at the sort itself.
+	checkConfigErrors(t, errs, []wantConfigError{
+		{4, "metrics[0].sort", false},
+	})
+}
+
+func TestValidateConfigFileSyntaxError(t *testing.T) {
+	path := writeConfig(t, `
+metrics:
+- metric: plays
+  names: [bitrate
+`)
+
+	errs, err := validateConfigFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(errs) != 1 || errs[0].line == 0 || errs[0].warning {
+		t.Errorf("got %v, want a single error with a line", errs)
+	}
+}
+
+func TestValidateCustomMetricIsOnlyAWarning(t *testing.T) {
+	path := writeConfig(t, `
+metrics:
+- metric: my_custom_metric
+  dimensions: [cdn]
+`)
+
+	if err := runValidate(path); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestValidateEmptyConfig(t *testing.T) {
+	path := writeConfig(t, "")
+
+	errs, err := validateConfigFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checkConfigErrors(t, errs, []wantConfigError{})
+}
+
+func TestValidateSortMetric(t *testing.T) {
+	path := writeConfig(t, `
+derived:
+- name: failure_rate
+  expression: video_start_failures / attempts
+metrics:
+- metric: plays
+  dimensions: [cdn]
+  sort:
+    metric: plays
+- metric: plays
+  dimensions: [cdn]
+  sort:
+    metric: plays.percentage
+- metric: plays
+  dimensions: [cdn]
+  sort:
+    metric: failure_rate
+- metric: my_custom_metric
+  dimensions: [cdn]
+  sort:
+    metric: my_custom_metric
+`)
+
+	errs, err := validateConfigFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checkConfigErrors(t, errs, []wantConfigError{
+		{11, "metrics[1].sort", false},
+		{15, "metrics[2].sort", false},
+		{17, "metrics[3].metric", true},
+		{20, "metrics[3].sort.metric", true},
+	})
+}
+
+func TestValidateUnknownDimensionsAreWarnings(t *testing.T) {
+	path := writeConfig(t, `
+metrics:
+- metric: plays
+  dimensions: [device-name, device_nmae, [cdn, geo_country_code]]
+  filters:
+    browser_name: [Chrome]
+    devise-name: [Roku]
+- metricGroup: quality-metriclens
+- metricGroup: quality-metricelns
+`)
+
+	errs, err := validateConfigFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checkConfigErrors(t, errs, []wantConfigError{
+		{3, "metrics[0].dimensions[1][0]", true},
+		{6, "metrics[0].filters.devise-name", true},
+		{8, "metrics[2].metricGroup", true},
+	})
+
+	if err := runValidate(path); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}